@@ -0,0 +1,282 @@
+// Package audit records who searched for what, so that lookups can be
+// reviewed after an incident. Each command is written as a single line of
+// JSON
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geckoboard/slash-infra/search"
+)
+
+// Where a command came from
+const (
+	SourceSlashCommand = "slash_command"
+	SourceAppMention   = "app_mention"
+	SourceShortcut     = "shortcut"
+
+	// A button on a result, e.g. to show an instance's console output
+	SourceAction = "action"
+
+	// A preview of a link to the AWS console
+	SourceUnfurl = "unfurl"
+
+	// A reply to a message that mentioned instance IDs
+	SourceMessage = "message"
+)
+
+// Fields that can be redacted with AUDIT_REDACT
+const (
+	FieldText        = "text"
+	FieldUserID      = "user_id"
+	FieldUserName    = "user_name"
+	FieldChannelID   = "channel_id"
+	FieldChannelName = "channel_name"
+
+	// Errors shown to the user often repeat what they typed
+	FieldErrors = "errors"
+)
+
+// Redacted replaces the value of redacted fields
+const Redacted = "[redacted]"
+
+// Record is everything we know about a single command
+type Record struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+
+	TeamID      string `json:"team_id"`
+	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name,omitempty"`
+	ChannelID   string `json:"channel_id"`
+	ChannelName string `json:"channel_name,omitempty"`
+
+	// e.g. /infra-search, and the subcommand it ran
+	Command    string `json:"command,omitempty"`
+	Subcommand string `json:"subcommand,omitempty"`
+
+	// What the user typed
+	Text string `json:"text"`
+
+	// The instance a button was clicked for, e.g.
+	// "PRODUCTION|eu-west-2|i-0123456789abcdef0"
+	Instance string `json:"instance,omitempty"`
+
+	Searches []Search `json:"searches"`
+	Errors   []string `json:"errors"`
+
+	// How long it took from receiving the command to responding
+	LatencyMS int64 `json:"latency_ms"`
+
+	mu      sync.Mutex
+	started time.Time
+}
+
+// Search is a single search run by a command. Commands like the shortcut
+// run several
+type Search struct {
+	Query     string         `json:"query"`
+	Resolvers []string       `json:"resolvers"`
+	Accounts  []Account      `json:"accounts"`
+	Results   map[string]int `json:"results"`
+}
+
+// Account is how searching an account went
+type Account struct {
+	Alias  string `json:"alias"`
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewRecord starts a record of a command that has just been received
+func NewRecord(source string) *Record {
+	now := time.Now()
+
+	return &Record{
+		Time:     now.UTC(),
+		started:  now,
+		Source:   source,
+		Searches: []Search{},
+		Errors:   []string{},
+	}
+}
+
+// AddSearch records what a search found. resolvers are the names of the
+// resolvers that ran
+func (r *Record) AddSearch(query search.Query, resolvers []string, results search.Results) {
+	if r == nil {
+		return
+	}
+
+	s := Search{
+		Query:     query.Text,
+		Resolvers: resolvers,
+		Accounts:  []Account{},
+		Results:   map[string]int{},
+	}
+
+	for _, account := range results.Accounts {
+		s.Accounts = append(s.Accounts, Account{Alias: account.Alias, State: string(account.State), Reason: account.Reason})
+	}
+
+	for _, set := range results.Sets {
+		s.Results[set.Kind] += set.Total
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Searches = append(r.Searches, s)
+}
+
+// AddError records something that went wrong, including errors shown to
+// the user such as an unknown flag
+func (r *Record) AddError(err error) {
+	if r == nil || err == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// Finish records how long the command took
+func (r *Record) Finish() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.LatencyMS = int64(time.Since(r.started) / time.Millisecond)
+}
+
+type recordKey struct{}
+
+// WithRecord returns a context that any search or error can be recorded to
+func WithRecord(ctx context.Context, record *Record) context.Context {
+	return context.WithValue(ctx, recordKey{}, record)
+}
+
+// FromContext returns the record being collected in ctx, or nil if there
+// isn't one. Every Record method can be called on nil
+func FromContext(ctx context.Context) *Record {
+	record, _ := ctx.Value(recordKey{}).(*Record)
+	return record
+}
+
+// Logger writes records as JSON, one per line
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	redact map[string]bool
+}
+
+// NewLogger writes records to w, replacing the value of any of the redact
+// fields (e.g. FieldText) with Redacted
+func NewLogger(w io.Writer, redact ...string) *Logger {
+	l := &Logger{w: w, redact: map[string]bool{}}
+	for _, field := range redact {
+		l.redact[strings.TrimSpace(strings.ToLower(field))] = true
+	}
+
+	return l
+}
+
+// FromEnvironment builds a logger configured by environment variables:
+//
+// `AUDIT_LOG_FILE` - A file to append records to. Records are written to
+// stdout if this isn't set
+//
+// `AUDIT_REDACT` - A comma separated list of fields to redact, e.g.
+// `text,user_name`
+func FromEnvironment() *Logger {
+	var w io.Writer = os.Stdout
+
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("could not open AUDIT_LOG_FILE: %s", err)
+		}
+		w = file
+	}
+
+	redact := []string{}
+	if fields := os.Getenv("AUDIT_REDACT"); fields != "" {
+		redact = strings.Split(fields, ",")
+	}
+
+	return NewLogger(w, redact...)
+}
+
+// Log writes a record. Failing to write the audit log is logged, but
+// doesn't stop the command
+func (l *Logger) Log(record *Record) {
+	if record == nil {
+		return
+	}
+
+	record.mu.Lock()
+	line, err := json.Marshal(l.redacted(record))
+	record.mu.Unlock()
+
+	if err != nil {
+		log.Printf("could not encode audit record: %s", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		log.Printf("could not write audit record: %s", err)
+	}
+}
+
+// redacted returns a copy of the record with the redacted fields replaced.
+// The caller must hold the record's lock
+func (l *Logger) redacted(record *Record) *Record {
+	r := &Record{
+		Time:        record.Time,
+		Source:      record.Source,
+		TeamID:      record.TeamID,
+		UserID:      l.redactField(FieldUserID, record.UserID),
+		UserName:    l.redactField(FieldUserName, record.UserName),
+		ChannelID:   l.redactField(FieldChannelID, record.ChannelID),
+		ChannelName: l.redactField(FieldChannelName, record.ChannelName),
+		Command:     record.Command,
+		Subcommand:  record.Subcommand,
+		Text:        l.redactField(FieldText, record.Text),
+		Instance:    record.Instance,
+		Searches:    make([]Search, len(record.Searches)),
+		Errors:      make([]string, len(record.Errors)),
+		LatencyMS:   record.LatencyMS,
+	}
+
+	for i, err := range record.Errors {
+		r.Errors[i] = l.redactField(FieldErrors, err)
+	}
+
+	for i, s := range record.Searches {
+		// The query is usually part of what the user typed
+		s.Query = l.redactField(FieldText, s.Query)
+		r.Searches[i] = s
+	}
+
+	return r
+}
+
+func (l *Logger) redactField(field, value string) string {
+	if l.redact[field] && value != "" {
+		return Redacted
+	}
+
+	return value
+}
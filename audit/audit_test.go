@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/geckoboard/slash-infra/search"
+)
+
+func TestLogger(t *testing.T) {
+	newRecord := func() *Record {
+		record := NewRecord(SourceSlashCommand)
+		record.TeamID = "T0001"
+		record.UserID = "U2147483697"
+		record.UserName = "steve"
+		record.ChannelID = "C2147483705"
+		record.ChannelName = "incidents"
+		record.Command = "/infra-search"
+		record.Subcommand = "search"
+		record.Text = "10.1.2.3 --account=production"
+
+		record.AddSearch(search.Query{Text: "10.1.2.3"}, []string{"EC2 instances and Elastic IPs"}, search.Results{
+			Sets: []search.ResultSet{
+				{Kind: "ec2.instance", Total: 2},
+				{Kind: "ec2.elastic_ip", Total: 0},
+			},
+			Accounts: []search.AccountStatus{
+				{Alias: "PRODUCTION", State: search.AccountOK},
+				{Alias: "STAGING", State: search.AccountDenied, Reason: "you're not allowed to search STAGING here"},
+			},
+		})
+		record.AddError(errors.New("I don't know the flag `--colour`"))
+		record.Finish()
+
+		return record
+	}
+
+	t.Run("It writes each record as a line of JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		logger := NewLogger(&out)
+
+		logger.Log(newRecord())
+		logger.Log(newRecord())
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 lines, got %q", out.String())
+		}
+
+		var logged map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[0]), &logged); err != nil {
+			t.Fatal(err)
+		}
+
+		if logged["user_name"] != "steve" || logged["text"] != "10.1.2.3 --account=production" || logged["source"] != "slash_command" {
+			t.Errorf("unexpected record %s", lines[0])
+		}
+
+		searches := logged["searches"].([]interface{})
+		first := searches[0].(map[string]interface{})
+		if first["results"].(map[string]interface{})["ec2.instance"] != float64(2) || len(first["accounts"].([]interface{})) != 2 {
+			t.Errorf("unexpected search %#v", first)
+		}
+
+		if _, ok := logged["latency_ms"]; !ok {
+			t.Errorf("expected the latency to be logged, got %s", lines[0])
+		}
+	})
+
+	t.Run("It redacts the configured fields", func(t *testing.T) {
+		var out bytes.Buffer
+		logger := NewLogger(&out, "text", " User_Name", "errors")
+
+		logger.Log(newRecord())
+
+		var logged Record
+		if err := json.Unmarshal(out.Bytes(), &logged); err != nil {
+			t.Fatal(err)
+		}
+
+		if logged.Text != Redacted || logged.UserName != Redacted || logged.Searches[0].Query != Redacted || logged.Errors[0] != Redacted {
+			t.Errorf("expected fields to be redacted, got %s", out.String())
+		}
+
+		if logged.UserID != "U2147483697" || logged.ChannelName != "incidents" {
+			t.Errorf("did not expect other fields to be redacted, got %s", out.String())
+		}
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	if record := FromContext(context.Background()); record != nil {
+		t.Fatalf("expected no record, got %#v", record)
+	}
+
+	// Searches outside of a command aren't audited, so recording to a nil
+	// record does nothing
+	FromContext(context.Background()).AddError(errors.New("missing"))
+
+	record := NewRecord(SourceAppMention)
+	ctx := WithRecord(context.Background(), record)
+	FromContext(ctx).AddError(errors.New("not_in_channel"))
+
+	if len(record.Errors) != 1 || record.Errors[0] != "not_in_channel" {
+		t.Errorf("unexpected errors %#v", record.Errors)
+	}
+}
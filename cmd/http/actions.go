@@ -0,0 +1,260 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+	"github.com/geckoboard/slash-infra/audit"
+	"github.com/geckoboard/slash-infra/policy"
+	"github.com/geckoboard/slash-infra/search"
+	"github.com/geckoboard/slash-infra/slackutil"
+)
+
+const (
+	instanceActionsBlockID = "ec2.instance.actions"
+
+	// Blocks showing the detail a button fetched all share this block ID, so
+	// that we can replace them when another button is clicked
+	instanceDetailBlockID = "ec2.instance.detail"
+
+	actionInstanceTags           = "ec2.instance.tags"
+	actionInstanceSecurityGroups = "ec2.instance.security_groups"
+	actionInstanceConsoleOutput  = "ec2.instance.console_output"
+
+	// The console output is shown in a single section, so we only show the
+	// end of it. This is measured after escaping
+	maxConsoleOutputLength = slackutil.MaxSectionTextLength - 100
+)
+
+// instanceDetailFetcher looks up more detail about an instance, and formats
+// it as blocks
+type instanceDetailFetcher func(ctx context.Context, ec2 *search.EC2Resolver, ref search.InstanceRef) ([]slackutil.Block, error)
+
+// instanceDetail is what a button on an instance shows
+type instanceDetail struct {
+	fetch instanceDetailFetcher
+
+	// Private detail (e.g. console output, which can include secrets) is
+	// only shown to the user who clicked, rather than added to the message
+	private bool
+}
+
+func registerInstanceActions(router *slackutil.InteractionRouter, ec2 *search.EC2Resolver, accessPolicy *policy.Policy, auditLog *audit.Logger) {
+	details := map[string]instanceDetail{
+		actionInstanceTags:           {fetch: fetchInstanceTags},
+		actionInstanceSecurityGroups: {fetch: fetchInstanceSecurityGroups},
+		actionInstanceConsoleOutput:  {fetch: fetchInstanceConsoleOutput, private: true},
+	}
+
+	for actionID, detail := range details {
+		router.HandleAction(actionID, slackutil.DelayedActionResponse{
+			Handler: instanceDetailHandler(ec2, accessPolicy, auditLog, detail),
+		})
+	}
+}
+
+// instanceDetailHandler replaces the original message with one that also
+// shows the detail the user asked for, or shows private detail to just the
+// user who clicked. Every click is written to the audit log, as the detail
+// (e.g. console output) can be sensitive
+func instanceDetailHandler(ec2 *search.EC2Resolver, accessPolicy *policy.Policy, auditLog *audit.Logger, instanceDetail instanceDetail) slackutil.ActionHandler {
+	return func(ctx context.Context, payload slackutil.InteractionPayload, action slackutil.Action, resp slackutil.MessageResponder) {
+		record := audit.NewRecord(audit.SourceAction)
+		record.TeamID = payload.Team.ID
+		record.UserID = payload.User.ID
+		record.UserName = payload.User.Username
+		record.ChannelID = payload.Channel.ID
+		record.ChannelName = payload.Channel.Name
+		record.Command = action.ActionID
+		record.Instance = action.Value
+
+		defer func() {
+			record.Finish()
+			auditLog.Log(record)
+		}()
+
+		ref, err := search.ParseInstanceRef(action.Value)
+		if err != nil {
+			record.AddError(err)
+			log.Print(err)
+			return
+		}
+
+		// The button could have been shared somewhere the account can't be
+		// searched from, e.g. by forwarding the message
+		req := policy.Request{TeamID: payload.Team.ID, ChannelID: payload.Channel.ID, UserID: payload.User.ID}
+		if !accessPolicy.Allows(ctx, req, ref.AccountAlias) {
+			denied := &policy.DeniedError{Aliases: []string{ref.AccountAlias}}
+			record.AddError(denied)
+			resp.EphemeralResponse(formatCommandError(denied))
+			return
+		}
+
+		detail, err := instanceDetail.fetch(ctx, ec2, ref)
+		if err != nil {
+			record.AddError(err)
+			bugsnag.Notify(err)
+			detail = []slackutil.Block{
+				slackutil.SectionBlock{
+					BlockID: instanceDetailBlockID,
+					Text:    slackutil.Markdown(fmt.Sprintf("⚠️ Couldn't look that up in %s: %s", ref.AccountAlias, err)),
+				},
+			}
+		}
+
+		// Detail about an account the user can only search because of who
+		// they are mustn't be added to a message everyone can see
+		private := instanceDetail.private ||
+			(!payload.Container.IsEphemeral && !accessPolicy.AllowsEveryone(ctx, req, ref.AccountAlias))
+
+		if private {
+			err = resp.EphemeralResponse(slackutil.Response{
+				Text:   fmt.Sprintf("Details for %s", ref.InstanceID),
+				Blocks: limitBlocks(detail, slackutil.MaxBlocksPerMessage),
+			})
+			record.AddError(err)
+			return
+		}
+
+		blocks := []slackutil.Block{}
+		if payload.Message != nil {
+			blocks = withoutInstanceDetail(payload.Message.Blocks)
+		}
+		blocks = limitBlocks(append(blocks, detail...), slackutil.MaxBlocksPerMessage)
+
+		response := slackutil.Response{
+			Text:            fmt.Sprintf("Details for %s", ref.InstanceID),
+			Blocks:          blocks,
+			ReplaceOriginal: true,
+		}
+
+		// The results may have been shown only to the user (e.g. with
+		// --private), in which case the detail mustn't be shared either
+		if payload.Container.IsEphemeral {
+			err = resp.EphemeralResponse(response)
+		} else {
+			err = resp.PublicResponse(response)
+		}
+		record.AddError(err)
+	}
+}
+
+// withoutInstanceDetail returns the original message's blocks, minus any
+// detail added by a previous button click
+func withoutInstanceDetail(original []json.RawMessage) []slackutil.Block {
+	blocks := []slackutil.Block{}
+
+	for _, raw := range original {
+		block := slackutil.RawBlock(raw)
+		if strings.HasPrefix(block.BlockID(), instanceDetailBlockID) {
+			continue
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// detailBlocks shows a heading and some preformatted text, split over
+// several sections if it's too long for one
+func detailBlocks(heading, text string) []slackutil.Block {
+	blocks := []slackutil.Block{
+		slackutil.DividerBlock{BlockID: instanceDetailBlockID},
+		slackutil.SectionBlock{
+			BlockID: fmt.Sprintf("%s.heading", instanceDetailBlockID),
+			Text:    slackutil.Markdown(heading),
+		},
+	}
+
+	for i, chunk := range splitLines(slackutil.EscapeText(text), slackutil.MaxSectionTextLength-len("```\n```")) {
+		blocks = append(blocks, slackutil.SectionBlock{
+			BlockID: fmt.Sprintf("%s.%d", instanceDetailBlockID, i),
+			Text:    slackutil.Markdown(fmt.Sprintf("```\n%s```", chunk)),
+		})
+	}
+
+	return blocks
+}
+
+func fetchInstanceTags(ctx context.Context, ec2 *search.EC2Resolver, ref search.InstanceRef) ([]slackutil.Block, error) {
+	tags, err := ec2.InstanceTags(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tags) == 0 {
+		return detailBlocks(fmt.Sprintf("🏷 `%s` has no tags", ref.InstanceID), ""), nil
+	}
+
+	lines := []string{}
+	for _, tag := range tags {
+		lines = append(lines, fmt.Sprintf("%s = %s\n", tag.Key, tag.Value))
+	}
+
+	return detailBlocks(fmt.Sprintf("🏷 Tags for `%s`", ref.InstanceID), strings.Join(lines, "")), nil
+}
+
+func fetchInstanceSecurityGroups(ctx context.Context, ec2 *search.EC2Resolver, ref search.InstanceRef) ([]slackutil.Block, error) {
+	groups, err := ec2.InstanceSecurityGroups(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	for _, group := range groups {
+		lines = append(lines, fmt.Sprintf("%s (%s)\n", group.ID, group.Name))
+		for _, rule := range group.Ingress {
+			lines = append(lines, fmt.Sprintf("  in:  %s\n", rule))
+		}
+		for _, rule := range group.Egress {
+			lines = append(lines, fmt.Sprintf("  out: %s\n", rule))
+		}
+	}
+
+	return detailBlocks(fmt.Sprintf("🛡 Security groups for `%s`", ref.InstanceID), strings.Join(lines, "")), nil
+}
+
+func fetchInstanceConsoleOutput(ctx context.Context, ec2 *search.EC2Resolver, ref search.InstanceRef) ([]slackutil.Block, error) {
+	output, err := ec2.InstanceConsoleOutput(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatConsoleOutput(ref.InstanceID, output), nil
+}
+
+// formatConsoleOutput shows the end of an instance's console output, which
+// is the most interesting part. It's cut down so that it fits in a single
+// section once it's been escaped
+func formatConsoleOutput(instanceID, output string) []slackutil.Block {
+	if strings.TrimSpace(output) == "" {
+		return detailBlocks(fmt.Sprintf("🖥 `%s` hasn't written anything to its console", instanceID), "")
+	}
+
+	runes := []rune(output)
+	start, length := len(runes), 0
+	for start > 0 {
+		escaped := len([]rune(slackutil.EscapeText(string(runes[start-1]))))
+		if length+escaped > maxConsoleOutputLength {
+			break
+		}
+
+		start--
+		length += escaped
+	}
+
+	// Start at a whole line if we can
+	if start > 0 {
+		output = string(runes[start:])
+		if newline := strings.Index(output, "\n"); newline >= 0 && newline < len(output)-1 {
+			output = output[newline+1:]
+		}
+	}
+
+	return detailBlocks(fmt.Sprintf("🖥 Latest console output for `%s`", instanceID), output)
+}
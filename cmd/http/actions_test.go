@@ -0,0 +1,326 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geckoboard/slash-infra/audit"
+	"github.com/geckoboard/slash-infra/policy"
+	"github.com/geckoboard/slash-infra/search"
+	"github.com/geckoboard/slash-infra/slackutil"
+)
+
+// auditBuffer collects audit records, which are written by handlers running
+// in the background
+type auditBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (a *auditBuffer) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.buf.Write(p)
+}
+
+// waitForRecord waits for a single audit record to be written
+func (a *auditBuffer) waitForRecord(t *testing.T) *audit.Record {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		a.mu.Lock()
+		line := a.buf.String()
+		a.mu.Unlock()
+
+		if strings.HasSuffix(line, "\n") {
+			record := &audit.Record{}
+			if err := json.Unmarshal([]byte(line), record); err != nil {
+				t.Fatalf("could not decode audit record %q: %s", line, err)
+			}
+			return record
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("no audit record was written")
+	return nil
+}
+
+func TestInstanceDetailHandler(t *testing.T) {
+	responses := make(chan map[string]interface{}, 10)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		responses <- body
+
+		w.Write([]byte("ok"))
+	}))
+	defer slack.Close()
+
+	var clickIn func(router *slackutil.InteractionRouter, container slackutil.InteractionContainer)
+	click := func(router *slackutil.InteractionRouter) {
+		clickIn(router, slackutil.InteractionContainer{Type: "message"})
+	}
+
+	clickIn = func(router *slackutil.InteractionRouter, container slackutil.InteractionContainer) {
+		payload, _ := json.Marshal(slackutil.InteractionPayload{
+			Type:        "block_actions",
+			Team:        slackutil.InteractionTeam{ID: "T1DC2JH3J"},
+			User:        slackutil.InteractionUser{ID: "U2CERLKJA", Username: "roadrunner"},
+			Channel:     slackutil.InteractionChannel{ID: "G8PSS9T3V", Name: "foobar"},
+			Container:   container,
+			ResponseURL: slack.URL,
+			Actions: []slackutil.Action{
+				{Type: "button", ActionID: actionInstanceConsoleOutput, Value: "PRODUCTION|eu-west-2|i-0123456789abcdef0"},
+			},
+		})
+
+		form := url.Values{}
+		form.Set("payload", string(payload))
+		r := httptest.NewRequest("POST", interactionsPath, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	fetch := func(ctx context.Context, ec2 *search.EC2Resolver, ref search.InstanceRef) ([]slackutil.Block, error) {
+		return detailBlocks("Console output", "booting"), nil
+	}
+
+	t.Run("It writes an audit record for every click", func(t *testing.T) {
+		records := &auditBuffer{}
+
+		router := slackutil.NewInteractionRouter()
+		router.HandleAction(actionInstanceConsoleOutput, slackutil.DelayedActionResponse{
+			Handler: instanceDetailHandler(nil, policy.New(nil, nil), audit.NewLogger(records), instanceDetail{fetch: fetch, private: true}),
+		})
+		click(router)
+
+		record := records.waitForRecord(t)
+		<-responses
+		if record.Source != audit.SourceAction || record.UserID != "U2CERLKJA" || record.ChannelID != "G8PSS9T3V" {
+			t.Errorf("unexpected record %s", records.buf.String())
+		}
+		if record.Command != actionInstanceConsoleOutput || record.Instance != "PRODUCTION|eu-west-2|i-0123456789abcdef0" {
+			t.Errorf("unexpected record %s", records.buf.String())
+		}
+		if len(record.Errors) != 0 {
+			t.Errorf("unexpected errors %#v", record.Errors)
+		}
+	})
+
+	t.Run("It only shows private detail to the user who clicked", func(t *testing.T) {
+		router := slackutil.NewInteractionRouter()
+		router.HandleAction(actionInstanceConsoleOutput, slackutil.DelayedActionResponse{
+			Handler: instanceDetailHandler(nil, policy.New(nil, nil), audit.NewLogger(&auditBuffer{}), instanceDetail{fetch: fetch, private: true}),
+		})
+		click(router)
+
+		select {
+		case response := <-responses:
+			if response["response_type"] != slackutil.ResponseEphemeral || response["replace_original"] == true {
+				t.Errorf("unexpected response %#v", response)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no response was sent")
+		}
+	})
+
+	t.Run("It keeps detail about ephemeral results private", func(t *testing.T) {
+		router := slackutil.NewInteractionRouter()
+		router.HandleAction(actionInstanceConsoleOutput, slackutil.DelayedActionResponse{
+			Handler: instanceDetailHandler(nil, policy.New(nil, nil), audit.NewLogger(&auditBuffer{}), instanceDetail{fetch: fetch}),
+		})
+		clickIn(router, slackutil.InteractionContainer{Type: "message", IsEphemeral: true})
+
+		select {
+		case response := <-responses:
+			if response["response_type"] != slackutil.ResponseEphemeral || response["replace_original"] != true {
+				t.Errorf("unexpected response %#v", response)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no response was sent")
+		}
+	})
+
+	t.Run("It adds detail to public results for everyone", func(t *testing.T) {
+		router := slackutil.NewInteractionRouter()
+		router.HandleAction(actionInstanceConsoleOutput, slackutil.DelayedActionResponse{
+			Handler: instanceDetailHandler(nil, policy.New(nil, nil), audit.NewLogger(&auditBuffer{}), instanceDetail{fetch: fetch}),
+		})
+		click(router)
+
+		select {
+		case response := <-responses:
+			if response["response_type"] != slackutil.ResponseInChannel || response["replace_original"] != true {
+				t.Errorf("unexpected response %#v", response)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no response was sent")
+		}
+	})
+
+	t.Run("It only shows detail to the user if the channel isn't allowed", func(t *testing.T) {
+		accessPolicy := policy.New(map[string]policy.Rule{
+			"PRODUCTION": {Channels: []string{"C0LAN2Q65"}, Users: []string{"U2CERLKJA"}},
+		}, nil)
+
+		router := slackutil.NewInteractionRouter()
+		router.HandleAction(actionInstanceConsoleOutput, slackutil.DelayedActionResponse{
+			Handler: instanceDetailHandler(nil, accessPolicy, audit.NewLogger(&auditBuffer{}), instanceDetail{fetch: fetch}),
+		})
+		click(router)
+
+		select {
+		case response := <-responses:
+			if response["response_type"] != slackutil.ResponseEphemeral || response["replace_original"] == true {
+				t.Errorf("unexpected response %#v", response)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no response was sent")
+		}
+	})
+
+	t.Run("It records clicks that weren't allowed", func(t *testing.T) {
+		records := &auditBuffer{}
+		accessPolicy := policy.New(map[string]policy.Rule{"PRODUCTION": {Channels: []string{"C0LAN2Q65"}}}, nil)
+
+		router := slackutil.NewInteractionRouter()
+		router.HandleAction(actionInstanceConsoleOutput, slackutil.DelayedActionResponse{
+			Handler: instanceDetailHandler(nil, accessPolicy, audit.NewLogger(records), instanceDetail{fetch: func(ctx context.Context, ec2 *search.EC2Resolver, ref search.InstanceRef) ([]slackutil.Block, error) {
+				t.Error("did not expect the detail to be fetched")
+				return nil, nil
+			}}),
+		})
+		click(router)
+
+		record := records.waitForRecord(t)
+		<-responses
+		if len(record.Errors) != 1 || record.Errors[0] != "you're not allowed to search PRODUCTION here" {
+			t.Errorf("unexpected errors %#v", record.Errors)
+		}
+	})
+}
+
+func TestMessageHandler(t *testing.T) {
+	t.Run("It writes an audit record when it looks up instance IDs", func(t *testing.T) {
+		records := &auditBuffer{}
+		h := httpServer{
+			resolvers: search.NewRegistry(),
+			policy:    policy.New(nil, nil),
+			audit:     audit.NewLogger(records),
+		}
+
+		h.messageHandler(context.Background(), slackutil.EventEnvelope{
+			TeamID: "T1DC2JH3J",
+			Event: slackutil.Event{
+				Type:    slackutil.EventMessage,
+				User:    "U2CERLKJA",
+				Channel: "C0LAN2Q65",
+				Text:    "is i-0123456789abcdef0 down?",
+			},
+		})
+
+		record := records.waitForRecord(t)
+		if record.Source != audit.SourceMessage || record.UserID != "U2CERLKJA" || record.ChannelID != "C0LAN2Q65" {
+			t.Errorf("unexpected record %s", records.buf.String())
+		}
+		if len(record.Searches) != 1 || record.Searches[0].Query != "i-0123456789abcdef0" {
+			t.Errorf("unexpected searches %#v", record.Searches)
+		}
+	})
+
+	t.Run("It leaves out accounts the user is only allowed to search because of who they are", func(t *testing.T) {
+		records := &auditBuffer{}
+		h := httpServer{
+			resolvers: search.NewRegistry(fakeResolver{accounts: []string{"DEV", "PRODUCTION"}}),
+			policy: policy.New(map[string]policy.Rule{
+				"PRODUCTION": {Channels: []string{"C0LAN2Q65"}, Users: []string{"U2CERLKJA"}},
+			}, nil),
+			audit: audit.NewLogger(records),
+		}
+
+		h.messageHandler(context.Background(), slackutil.EventEnvelope{
+			TeamID: "T1DC2JH3J",
+			Event: slackutil.Event{
+				Type:    slackutil.EventMessage,
+				User:    "U2CERLKJA",
+				Channel: "G8PSS9T3V",
+				Text:    "is i-0123456789abcdef0 down?",
+			},
+		})
+
+		record := records.waitForRecord(t)
+		if len(record.Searches) != 1 {
+			t.Fatalf("unexpected searches %#v", record.Searches)
+		}
+
+		expected := []audit.Account{
+			{Alias: "DEV", State: string(search.AccountOK)},
+			{Alias: "PRODUCTION", State: string(search.AccountDenied), Reason: "you're not allowed to search PRODUCTION here"},
+		}
+		if !reflect.DeepEqual(record.Searches[0].Accounts, expected) {
+			t.Errorf("unexpected accounts %#v", record.Searches[0].Accounts)
+		}
+	})
+
+	t.Run("It doesn't audit messages that don't mention instances", func(t *testing.T) {
+		records := &auditBuffer{}
+		h := httpServer{audit: audit.NewLogger(records)}
+
+		h.messageHandler(context.Background(), slackutil.EventEnvelope{
+			Event: slackutil.Event{Type: slackutil.EventMessage, User: "U2CERLKJA", Text: "good morning"},
+		})
+
+		if records.buf.Len() != 0 {
+			t.Errorf("unexpected audit record %q", records.buf.String())
+		}
+	})
+}
+
+func TestFormatConsoleOutput(t *testing.T) {
+	sectionText := func(blocks []slackutil.Block) []string {
+		texts := []string{}
+		for _, block := range blocks {
+			if section, ok := block.(slackutil.SectionBlock); ok {
+				texts = append(texts, section.Text.Text)
+			}
+		}
+		return texts
+	}
+
+	t.Run("It escapes the output", func(t *testing.T) {
+		texts := sectionText(formatConsoleOutput("i-0123456789abcdef0", "<!channel> login: & ready\n"))
+
+		if len(texts) != 2 || texts[1] != "```\n&lt;!channel&gt; login: &amp; ready\n```" {
+			t.Errorf("unexpected sections %#v", texts)
+		}
+	})
+
+	t.Run("It only shows as much of the end as fits in one section", func(t *testing.T) {
+		output := strings.Repeat("first line\n", 10) + strings.Repeat("<", maxConsoleOutputLength)
+		blocks := formatConsoleOutput("i-0123456789abcdef0", output)
+
+		texts := sectionText(blocks)
+		if len(texts) != 2 {
+			t.Fatalf("expected a heading and one section, got %d sections", len(texts))
+		}
+		if strings.Contains(texts[1], "first line") || !strings.HasSuffix(texts[1], "&lt;```") {
+			t.Errorf("expected only the end of the output, got %q", texts[1][:50])
+		}
+		if err := slackutil.ValidateBlocks(blocks); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+	"github.com/geckoboard/slash-infra/audit"
+	"github.com/geckoboard/slash-infra/command"
+	"github.com/geckoboard/slash-infra/policy"
+	"github.com/geckoboard/slash-infra/slackutil"
+)
+
+// appMentionHandler runs whatever someone wrote after mentioning the bot,
+// e.g. "@infra i-0123456789abcdef0", and replies in a thread. It understands
+// the same subcommands and flags as the slash command
+func (h httpServer) appMentionHandler(ctx context.Context, envelope slackutil.EventEnvelope) {
+	event := envelope.Event
+
+	// Don't get in to a conversation with another bot
+	if event.BotID != "" {
+		return
+	}
+
+	text := slackutil.PlainMessageText(event.Text)
+
+	record := eventRecord(audit.SourceAppMention, envelope, text)
+	ctx = audit.WithRecord(ctx, record)
+
+	defer func() {
+		record.Finish()
+		h.audit.Log(record)
+	}()
+
+	var (
+		response slackutil.Response
+		private  bool
+	)
+
+	if text == "" {
+		response = slackutil.Response{
+			Text: fmt.Sprintf("👋 Mention me with something to search for, e.g.\n%s", formatQueryHints(h.resolvers.Examples())),
+		}
+	} else if cmd, err := command.Parse(text); err != nil {
+		record.AddError(err)
+		response = formatCommandError(err)
+	} else {
+		record.Subcommand = cmd.Name
+
+		// Results from an account the user may only search because of who
+		// they are, not where, are only shown to them
+		var personal bool
+		response, personal = h.runCommand(ctx, requestFromEvent(envelope), cmd)
+		private = cmd.Private || personal
+	}
+
+	var err error
+	if private {
+		err = h.slack.PostEphemeral(ctx, slackutil.EphemeralMessage{
+			Channel:  event.Channel,
+			User:     event.User,
+			ThreadTS: event.ReplyThreadTS(),
+			Text:     response.Text,
+			Blocks:   response.Blocks,
+		})
+	} else {
+		_, err = h.slack.PostMessage(ctx, slackutil.Message{
+			Channel:  event.Channel,
+			ThreadTS: event.ReplyThreadTS(),
+			Text:     response.Text,
+			Blocks:   response.Blocks,
+		})
+	}
+
+	if err != nil {
+		record.AddError(err)
+		log.Print(err)
+		bugsnag.Notify(err)
+	}
+}
+
+func requestFromEvent(envelope slackutil.EventEnvelope) policy.Request {
+	return policy.Request{TeamID: envelope.TeamID, ChannelID: envelope.Event.Channel, UserID: envelope.Event.User}
+}
+
+// eventRecord starts an audit record of an event, which was caused by
+// whoever sent the message
+func eventRecord(source string, envelope slackutil.EventEnvelope, text string) *audit.Record {
+	record := audit.NewRecord(source)
+	record.TeamID = envelope.TeamID
+	record.UserID = envelope.Event.User
+	record.ChannelID = envelope.Event.Channel
+	record.Text = text
+
+	return record
+}
@@ -0,0 +1,700 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/geckoboard/slash-infra/command"
+	"github.com/geckoboard/slash-infra/search"
+	"github.com/geckoboard/slash-infra/slackutil"
+)
+
+// slashCommandName is what the slash command is called in the slack app
+const slashCommandName = "/infra-search"
+
+// resultSetFormatters turn a set of results of a particular kind into slack
+// blocks. Kinds without a formatter are shown using FormatResultAsBlocks
+var resultSetFormatters = map[string]func(search.ResultSet) []slackutil.Block{
+	"ec2.instance":   formatEc2Instances,
+	"ec2.elastic_ip": formatElasticIPs,
+}
+
+// FormatResults builds a slack response showing everything the resolvers
+// found for a query. recognised should be false if none of the resolvers
+// knew how to search for the query, in which case the examples are shown
+func FormatResults(query string, recognised bool, results search.Results, examples []search.Example) slackutil.Response {
+	blocks := []slackutil.Block{}
+
+	if results.Empty() {
+		blocks = append(blocks, FormatNoResults(query, recognised, examples)...)
+	}
+
+	for _, setOfResults := range results.Sets {
+		if len(setOfResults.Results) == 0 {
+			continue
+		}
+
+		format, ok := resultSetFormatters[setOfResults.Kind]
+		if !ok {
+			format = formatGenericResults
+		}
+
+		blocks = append(blocks, format(setOfResults)...)
+	}
+
+	// The footer is added after limiting the number of blocks, so that it's
+	// always shown
+	blocks = limitBlocks(blocks, slackutil.MaxBlocksPerMessage-1)
+
+	if footer := formatAccountsFooter(results); footer != "" {
+		blocks = append(blocks, slackutil.ContextBlock{
+			Elements: []*slackutil.TextObject{slackutil.Markdown(footer)},
+		})
+	}
+
+	return slackutil.Response{
+		// Only shown in notifications, as the message has blocks
+		Text:   fmt.Sprintf("Search results for %s", slackutil.EscapeText(query)),
+		Blocks: blocks,
+	}
+}
+
+// Each identifier in a summary only lists its first few results
+const maxSummaryResultsPerIdentifier = 5
+
+// FormatIdentifierSummary builds a single response describing what was found
+// for each of the identifiers mentioned in a message. omitted is the number
+// of identifiers that weren't looked up
+func FormatIdentifierSummary(found []identifierResults, omitted int) slackutil.Response {
+	heading := fmt.Sprintf("*Looked up %d things mentioned in this message*", len(found))
+	if len(found) == 1 {
+		heading = "*Looked up 1 thing mentioned in this message*"
+	}
+	if omitted > 0 {
+		heading = fmt.Sprintf("%s (%d more were left out)", heading, omitted)
+	}
+
+	blocks := []slackutil.Block{
+		slackutil.SectionBlock{Text: slackutil.Markdown(heading)},
+	}
+
+	accounts := [][]search.AccountStatus{}
+
+	for _, f := range found {
+		lines := []string{fmt.Sprintf("`%s`", f.identifier.Query().Text)}
+
+		shown, total, incomplete := 0, 0, false
+		for _, set := range f.results.Sets {
+			total += set.Total
+			incomplete = incomplete || set.Incomplete
+			for _, result := range set.Results {
+				if shown < maxSummaryResultsPerIdentifier {
+					lines = append(lines, fmt.Sprintf("• %s", formatResultLine(result)))
+					shown++
+				}
+			}
+		}
+
+		switch {
+		case total == 0:
+			lines = append(lines, "🤷 nothing found")
+		case incomplete:
+			lines = append(lines, fmt.Sprintf("…and %d+ more", total-shown))
+		case total > shown:
+			lines = append(lines, fmt.Sprintf("…and %d more", total-shown))
+		}
+
+		blocks = append(blocks, slackutil.SectionBlock{
+			Text: slackutil.Markdown(strings.Join(lines, "\n")),
+		})
+
+		accounts = append(accounts, f.results.Accounts)
+	}
+
+	blocks = limitBlocks(blocks, slackutil.MaxBlocksPerMessage-1)
+
+	if footer := formatAccountsFooter(search.Results{Accounts: worstAccountStatuses(accounts...)}); footer != "" {
+		blocks = append(blocks, slackutil.ContextBlock{
+			Elements: []*slackutil.TextObject{slackutil.Markdown(footer)},
+		})
+	}
+
+	return slackutil.Response{
+		Text:   strings.TrimSuffix(strings.TrimPrefix(heading, "*"), "*"),
+		Blocks: blocks,
+	}
+}
+
+// formatResultLine describes a result in a single line
+func formatResultLine(result search.Result) string {
+	switch result.Kind {
+	case "ec2.instance":
+		line := fmt.Sprintf("<%s|%s>", result.GetLink("ec2_console"), result.GetMetadata("instance_id"))
+		if name := result.GetMetadata("tag:Name"); name != "" {
+			line = fmt.Sprintf("%s `%s`", line, name)
+		}
+
+		return fmt.Sprintf(
+			"%s is a `%s` `%s` in %s",
+			line,
+			result.GetMetadata("instance_state"),
+			result.GetMetadata("instance_type"),
+			result.Location(),
+		)
+
+	case "ec2.elastic_ip":
+		return fmt.Sprintf("Elastic IP <%s|%s> in %s", result.GetLink("ec2_console"), result.GetMetadata("public_ip"), result.Location())
+
+	default:
+		return fmt.Sprintf("*%s* in %s", result.Kind, orDash(result.Location()))
+	}
+}
+
+// worstAccountStatuses combines the accounts from several searches, keeping
+// the worst thing that happened to each account, so a footer summarising
+// several searches still mentions every account that failed
+func worstAccountStatuses(searches ...[]search.AccountStatus) []search.AccountStatus {
+	severity := map[search.AccountState]int{
+		search.AccountSkipped:  0,
+		search.AccountDenied:   0,
+		search.AccountOK:       1,
+		search.AccountTimedOut: 2,
+		search.AccountError:    3,
+	}
+
+	worst := []search.AccountStatus{}
+	index := map[string]int{}
+
+	for _, accounts := range searches {
+		for _, account := range accounts {
+			i, ok := index[account.Alias]
+			if !ok {
+				index[account.Alias] = len(worst)
+				worst = append(worst, account)
+				continue
+			}
+
+			if severity[account.State] > severity[worst[i].State] {
+				worst[i] = account
+			}
+		}
+	}
+
+	return worst
+}
+
+// formatCommandError explains what was wrong with a command. Anything other
+// than a usage error shouldn't happen, so isn't explained
+func formatCommandError(err error) slackutil.Response {
+	message := "something went wrong"
+	if usageErr, ok := err.(*command.UsageError); ok {
+		message = usageErr.Message
+	}
+
+	return slackutil.Response{
+		Text: fmt.Sprintf("😕 Sorry, %s. Try `%s help` to see what I understand", message, slashCommandName),
+	}
+}
+
+// FormatHelp explains what can be searched for, the subcommands and flags,
+// and which accounts are searched. Everything is generated from the
+// resolvers, so that it stays up to date
+func FormatHelp(descriptions []search.Description, accounts []search.Account) slackutil.Response {
+	blocks := []slackutil.Block{
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf("*Usage*\n%s", formatUsages(slashCommandName+" ", command.Subcommands))),
+		},
+	}
+
+	for _, description := range descriptions {
+		blocks = append(blocks, slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf("*%s*\nSearch for:\n%s", description.Name, formatQueryHints(description.Examples))),
+		})
+	}
+
+	blocks = append(blocks,
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf("*Flags*\n%s", formatUsages("", command.Flags))),
+		},
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf("*Accounts*\n%s", formatAccountRegions(accounts))),
+		},
+	)
+
+	return slackutil.Response{
+		Text:   fmt.Sprintf("How to use %s", slashCommandName),
+		Blocks: blocks,
+	}
+}
+
+// formatAccountRegions lists each account alias once, along with the
+// regions it's searched in, e.g.
+//
+// • `PRODUCTION` in eu-west-2, us-east-1
+func formatAccountRegions(accounts []search.Account) string {
+	if len(accounts) == 0 {
+		return "I'm not configured to search any accounts"
+	}
+
+	aliases := []string{}
+	regions := map[string][]string{}
+
+	for _, account := range accounts {
+		if _, seen := regions[account.Alias]; !seen {
+			aliases = append(aliases, account.Alias)
+		}
+		regions[account.Alias] = append(regions[account.Alias], orDash(account.Region))
+	}
+
+	lines := []string{}
+	for _, alias := range aliases {
+		lines = append(lines, fmt.Sprintf("• `%s` in %s", alias, strings.Join(regions[alias], ", ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatUsages(prefix string, usages []command.Usage) string {
+	lines := []string{}
+
+	for _, usage := range usages {
+		syntax := prefix + usage.Name
+		switch {
+		case strings.HasPrefix(usage.Args, "="):
+			syntax += usage.Args
+		case usage.Args != "":
+			syntax += " " + usage.Args
+		}
+
+		lines = append(lines, fmt.Sprintf("• `%s` %s", slackutil.EscapeText(syntax), usage.Description))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// FormatAccounts lists the accounts and regions that are searched
+func FormatAccounts(accounts []search.Account) slackutil.Response {
+	if len(accounts) == 0 {
+		return slackutil.Response{Text: "🤷 I'm not configured to search any accounts"}
+	}
+
+	lines := []string{}
+	for _, account := range accounts {
+		lines = append(lines, fmt.Sprintf("• `%s` in `%s`", account.Alias, orDash(account.Region)))
+	}
+
+	return slackutil.Response{
+		Text: fmt.Sprintf("I search %d accounts", len(accounts)),
+		Blocks: []slackutil.Block{
+			slackutil.SectionBlock{
+				Text: slackutil.Markdown(fmt.Sprintf("*I search these accounts*\n%s", strings.Join(lines, "\n"))),
+			},
+			slackutil.ContextBlock{
+				Elements: []*slackutil.TextObject{
+					slackutil.Markdown(fmt.Sprintf("Search just one of them with e.g. `%s --account=%s i-0123456789abcdef0`", slashCommandName, accounts[0].Alias)),
+				},
+			},
+		},
+	}
+}
+
+// limitBlocks stops a message going over slack's limit on the number of
+// blocks, replacing anything that doesn't fit with a note
+func limitBlocks(blocks []slackutil.Block, limit int) []slackutil.Block {
+	if len(blocks) <= limit {
+		return blocks
+	}
+
+	return append(blocks[:limit-1:limit-1], slackutil.ContextBlock{
+		Elements: []*slackutil.TextObject{slackutil.Markdown("✂️ Some results were left out, try a more specific search")},
+	})
+}
+
+// FormatNoResults explains that nothing was found. If no resolver understood
+// the query then we list the kinds of query that are supported
+func FormatNoResults(query string, recognised bool, examples []search.Example) []slackutil.Block {
+	if recognised {
+		return []slackutil.Block{
+			slackutil.SectionBlock{
+				Text: slackutil.Markdown(fmt.Sprintf("🤷 Couldn't find anything matching `%s`", slackutil.EscapeText(query))),
+			},
+		}
+	}
+
+	return []slackutil.Block{
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf(
+				"🤔 I don't know how to search for `%s`. Try searching for:\n%s",
+				slackutil.EscapeText(query),
+				formatQueryHints(examples),
+			)),
+		},
+	}
+}
+
+func formatQueryHints(examples []search.Example) string {
+	hints := []string{}
+	for _, example := range examples {
+		hints = append(hints, fmt.Sprintf("• %s, e.g. `%s`", example.Description, example.Query))
+	}
+
+	return strings.Join(hints, "\n")
+}
+
+// formatAccountsFooter summarises how many accounts were searched, so that
+// an account we couldn't search doesn't look the same as "not found", e.g.
+//
+// searched 5 accounts, 1 failed: PRODUCTION (AccessDenied)
+func formatAccountsFooter(results search.Results) string {
+	denied := results.AccountsWith(search.AccountDenied)
+	searched := len(results.Accounts) - len(results.AccountsWith(search.AccountSkipped)) - len(denied)
+	if searched <= 0 {
+		return ""
+	}
+
+	noun := "accounts"
+	if searched == 1 {
+		noun = "account"
+	}
+	parts := []string{fmt.Sprintf("searched %d %s", searched, noun)}
+
+	if failed := results.AccountsWith(search.AccountError); len(failed) > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed: %s", len(failed), formatAccountList(failed)))
+	}
+
+	if timedOut := results.AccountsWith(search.AccountTimedOut); len(timedOut) > 0 {
+		parts = append(parts, fmt.Sprintf("%d timed out: %s", len(timedOut), formatAccountList(timedOut)))
+	}
+
+	if len(denied) > 0 {
+		aliases := []string{}
+		for _, account := range denied {
+			aliases = append(aliases, account.Alias)
+		}
+		parts = append(parts, fmt.Sprintf("you're not allowed to search %s here", strings.Join(aliases, ", ")))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func formatAccountList(accounts []search.AccountStatus) string {
+	names := []string{}
+
+	for _, account := range accounts {
+		if account.Reason != "" {
+			names = append(names, fmt.Sprintf("%s (%s)", account.Alias, account.Reason))
+		} else {
+			names = append(names, account.Alias)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func formatEc2Instances(instances search.ResultSet) []slackutil.Block {
+	if len(instances.Results) == 1 && !instances.Truncated() {
+		return FormatEc2InstanceAsBlocks(instances.Results[0])
+	}
+
+	return FormatEc2InstancesAsList(instances)
+}
+
+func formatElasticIPs(addresses search.ResultSet) []slackutil.Block {
+	blocks := []slackutil.Block{}
+
+	for _, address := range addresses.Results {
+		blocks = append(blocks, FormatElasticIPAsBlocks(address)...)
+	}
+
+	return blocks
+}
+
+func formatGenericResults(results search.ResultSet) []slackutil.Block {
+	blocks := []slackutil.Block{}
+
+	for _, result := range results.Results {
+		blocks = append(blocks, FormatResultAsBlocks(result)...)
+	}
+
+	return blocks
+}
+
+// field formats a title and value as one of a section's fields. Slack
+// doesn't allow empty fields, so missing values are shown as a dash
+func field(title, value string) *slackutil.TextObject {
+	return slackutil.Markdown(fmt.Sprintf("*%s*\n%s", title, orDash(value)))
+}
+
+func FormatEc2InstanceAsBlocks(instance search.Result) []slackutil.Block {
+	fields := []*slackutil.TextObject{
+		field("Account", instance.Location()),
+		field("Environment", instance.GetMetadata("tag:Environment")),
+		field("Role", instance.GetMetadata("tag:Role")),
+	}
+
+	if publicIps := instance.GetMetadata("public_ips"); publicIps != "" {
+		fields = append(fields, field("Public IP(s)", publicIps))
+	}
+	if privateIps := instance.GetMetadata("private_ips"); privateIps != "" {
+		fields = append(fields, field("Private IP(s)", privateIps))
+	}
+
+	links := []*slackutil.TextObject{
+		slackutil.Markdown(fmt.Sprintf("⏳ <%s|AWS config timeline>", instance.GetLink("config_timeline"))),
+	}
+	if switchRole := instance.GetLink("switch_role"); switchRole != "" {
+		links = append(links, slackutil.Markdown(fmt.Sprintf("🔑 <%s|Switch role to %s>", switchRole, instance.AccountAlias)))
+	}
+
+	return []slackutil.Block{
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf(
+				"Instance <%s|%s> is a `%s` `%s` in `%s`",
+				instance.GetLink("ec2_console"),
+				instance.GetMetadata("instance_id"),
+				instance.GetMetadata("instance_state"),
+				instance.GetMetadata("instance_type"),
+				instance.GetMetadata("az"),
+			)),
+			Fields: fields,
+		},
+		slackutil.ContextBlock{Elements: links},
+		instanceActions(instance),
+	}
+}
+
+// FormatResultPreview is a compact summary of a single result, for link
+// previews and replies to messages that mention a resource
+func FormatResultPreview(result search.Result) []slackutil.Block {
+	switch result.Kind {
+	case "ec2.instance":
+		return formatEc2InstancePreview(result)
+	case "ec2.elastic_ip":
+		return FormatElasticIPAsBlocks(result)
+	default:
+		return FormatResultAsBlocks(result)
+	}
+}
+
+func formatEc2InstancePreview(instance search.Result) []slackutil.Block {
+	text := fmt.Sprintf("Instance <%s|%s>", instance.GetLink("ec2_console"), instance.GetMetadata("instance_id"))
+	if name := instance.GetMetadata("tag:Name"); name != "" {
+		text = fmt.Sprintf("%s `%s`", text, name)
+	}
+
+	ips := []string{}
+	for _, key := range []string{"public_ips", "private_ips"} {
+		if value := instance.GetMetadata(key); value != "" {
+			ips = append(ips, value)
+		}
+	}
+
+	return []slackutil.Block{
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(text),
+			Fields: []*slackutil.TextObject{
+				field("State", instance.GetMetadata("instance_state")),
+				field("Type", instance.GetMetadata("instance_type")),
+				field("Account", instance.Location()),
+				field("IP(s)", strings.Join(ips, ", ")),
+			},
+		},
+	}
+}
+
+// instanceActions are buttons that show more detail about an instance. Each
+// button's value identifies the instance, along with the account and region
+// it's in
+func instanceActions(instance search.Result) slackutil.ActionsBlock {
+	ref := search.RefForResult(instance).String()
+
+	return slackutil.ActionsBlock{
+		BlockID: instanceActionsBlockID,
+		Elements: []slackutil.BlockElement{
+			slackutil.ButtonElement{ActionID: actionInstanceTags, Text: slackutil.PlainText("🏷 Tags"), Value: ref},
+			slackutil.ButtonElement{ActionID: actionInstanceSecurityGroups, Text: slackutil.PlainText("🛡 Security groups"), Value: ref},
+			slackutil.ButtonElement{ActionID: actionInstanceConsoleOutput, Text: slackutil.PlainText("🖥 Console output"), Value: ref},
+		},
+	}
+}
+
+// FormatEc2InstancesAsList summarises several instances as a table with one
+// line each, for searches (e.g. by name) that match more than one instance
+func FormatEc2InstancesAsList(instances search.ResultSet) []slackutil.Block {
+	var table bytes.Buffer
+
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATE\tACCOUNT\tPRIVATE IP")
+
+	for _, instance := range instances.Results {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\n",
+			instance.GetMetadata("instance_id"),
+			truncate(orDash(instance.GetMetadata("tag:Name")), maxTableNameLength),
+			instance.GetMetadata("instance_state"),
+			orDash(instance.Location()),
+			orDash(instance.GetMetadata("private_ips")),
+		)
+	}
+	w.Flush()
+
+	blocks := []slackutil.Block{
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf("*%s*", formatResultCount(instances, "instances"))),
+		},
+	}
+
+	// Big tables are split over several sections, as each section's text
+	// has to fit within slack's limit
+	for _, chunk := range splitLines(table.String(), slackutil.MaxSectionTextLength-len("```\n```")) {
+		blocks = append(blocks, slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf("```\n%s```", chunk)),
+		})
+	}
+
+	return blocks
+}
+
+// splitLines splits text into chunks of whole lines that are at most
+// maxLength characters long
+func splitLines(text string, maxLength int) []string {
+	chunks := []string{}
+	chunk := ""
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+
+		if chunk != "" && len([]rune(chunk))+len([]rune(line)) > maxLength {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+		chunk += line
+	}
+
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// Long names make every row of the table wrap on narrow screens
+const maxTableNameLength = 30
+
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+
+	return string(runes[:length-1]) + "…"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// formatResultCount describes how many results were found, e.g. "Found 3
+// instances" or "Showing 50 of 312 instances". If an account ran out of time
+// there may be more than we know about, e.g. "Showing 50 of 120+ instances"
+func formatResultCount(results search.ResultSet, noun string) string {
+	if results.Incomplete {
+		return fmt.Sprintf("Showing %d of %d+ %s", len(results.Results), results.Total, noun)
+	}
+
+	if results.Truncated() {
+		return fmt.Sprintf("Showing %d of %d %s", len(results.Results), results.Total, noun)
+	}
+
+	return fmt.Sprintf("Found %d %s", len(results.Results), noun)
+}
+
+func FormatElasticIPAsBlocks(address search.Result) []slackutil.Block {
+	fields := []*slackutil.TextObject{
+		field("Account", address.Location()),
+		field("Allocation ID", address.GetMetadata("allocation_id")),
+	}
+
+	if networkInterface := address.GetMetadata("network_interface_id"); networkInterface != "" {
+		fields = append(fields, field("Network interface", networkInterface))
+	}
+
+	description := "is not attached to anything"
+	if address.GetMetadata("network_interface_id") != "" {
+		description = "is not attached to an instance"
+	}
+
+	return []slackutil.Block{
+		slackutil.SectionBlock{
+			Text: slackutil.Markdown(fmt.Sprintf(
+				"Elastic IP <%s|%s> %s",
+				address.GetLink("ec2_console"),
+				address.GetMetadata("public_ip"),
+				description,
+			)),
+			Fields: fields,
+		},
+	}
+}
+
+// FormatResultAsBlocks shows every piece of metadata for a result. It's used
+// for kinds of result that don't have a dedicated formatter, so that new
+// resolvers are visible in slack straight away
+func FormatResultAsBlocks(result search.Result) []slackutil.Block {
+	keys := []string{}
+	for key := range result.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := []*slackutil.TextObject{}
+	for _, key := range keys {
+		if value := result.GetMetadata(key); value != "" {
+			fields = append(fields, field(key, value))
+		}
+	}
+
+	links := []string{}
+	for name, url := range result.Links {
+		links = append(links, fmt.Sprintf("<%s|%s>", url, name))
+	}
+	sort.Strings(links)
+
+	text := fmt.Sprintf("*%s*", result.Kind)
+	if location := result.Location(); location != "" {
+		text = fmt.Sprintf("%s in %s", text, location)
+	}
+	if len(links) > 0 {
+		text = fmt.Sprintf("%s\n%s", text, strings.Join(links, " "))
+	}
+
+	blocks := []slackutil.Block{}
+
+	// Sections can only have a limited number of fields, so results with
+	// lots of metadata are split over several sections
+	for start := 0; start == 0 || start < len(fields); start += slackutil.MaxSectionFields {
+		end := start + slackutil.MaxSectionFields
+		if end > len(fields) {
+			end = len(fields)
+		}
+
+		section := slackutil.SectionBlock{Fields: fields[start:end]}
+		if start == 0 {
+			section.Text = slackutil.Markdown(text)
+		}
+
+		blocks = append(blocks, section)
+	}
+
+	return blocks
+}
@@ -0,0 +1,255 @@
+package http
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/geckoboard/slash-infra/search"
+	"github.com/geckoboard/slash-infra/slackutil"
+)
+
+func TestFormatAccountsFooter(t *testing.T) {
+	t.Run("It lists the accounts that failed along with the reason", func(t *testing.T) {
+		results := search.Results{
+			Accounts: []search.AccountStatus{
+				search.AccountStatus{Alias: "DEV", State: search.AccountOK},
+				search.AccountStatus{Alias: "STAGING", State: search.AccountOK},
+				search.AccountStatus{Alias: "PRODUCTION", State: search.AccountError, Reason: "AccessDenied"},
+				search.AccountStatus{Alias: "LEGACY", State: search.AccountTimedOut},
+				search.AccountStatus{Alias: "DEV_US", State: search.AccountSkipped, Reason: "not in eu-west-2"},
+			},
+		}
+
+		expected := "searched 4 accounts, 1 failed: PRODUCTION (AccessDenied), 1 timed out: LEGACY"
+		if footer := formatAccountsFooter(results); footer != expected {
+			t.Errorf("unexpected footer %q", footer)
+		}
+	})
+
+	t.Run("It mentions the accounts that couldn't be searched from here", func(t *testing.T) {
+		results := search.Results{
+			Accounts: []search.AccountStatus{
+				search.AccountStatus{Alias: "DEV", State: search.AccountOK},
+				search.AccountStatus{Alias: "PRODUCTION", State: search.AccountDenied},
+			},
+		}
+
+		expected := "searched 1 account, you're not allowed to search PRODUCTION here"
+		if footer := formatAccountsFooter(results); footer != expected {
+			t.Errorf("unexpected footer %q", footer)
+		}
+	})
+
+	t.Run("It is empty when no accounts were searched", func(t *testing.T) {
+		if footer := formatAccountsFooter(search.Results{}); footer != "" {
+			t.Errorf("unexpected footer %q", footer)
+		}
+	})
+}
+
+func TestFormatEc2InstancesAsList(t *testing.T) {
+	instance := func(id, name string) search.Result {
+		return search.Result{
+			Kind:         "ec2.instance",
+			AccountAlias: "PRODUCTION",
+			Region:       "eu-west-2",
+			Metadata: map[string][]string{
+				"instance_id":    []string{id},
+				"instance_state": []string{"running"},
+				"private_ips":    []string{"10.1.2.3"},
+				"tag:Name":       []string{name},
+			},
+		}
+	}
+
+	t.Run("It says when there may be more instances than were counted", func(t *testing.T) {
+		blocks := FormatEc2InstancesAsList(search.ResultSet{
+			Kind:       "ec2.instance",
+			Results:    []search.Result{instance("i-0123456789abcdef0", "api-worker-1")},
+			Total:      1,
+			Incomplete: true,
+		})
+
+		if heading := blocks[0].(slackutil.SectionBlock).Text.Text; heading != "*Showing 1 of 1+ instances*" {
+			t.Errorf("unexpected heading %q", heading)
+		}
+	})
+
+	t.Run("It shows one line per instance and how many were left out", func(t *testing.T) {
+		blocks := FormatEc2InstancesAsList(search.ResultSet{
+			Kind: "ec2.instance",
+			Results: []search.Result{
+				instance("i-0123456789abcdef0", "api-worker-1"),
+				instance("i-0fedcba9876543210", ""),
+			},
+			Total: 312,
+		})
+
+		if len(blocks) != 2 {
+			t.Fatalf("expected a heading and a table, got %d blocks", len(blocks))
+		}
+
+		if heading := blocks[0].(slackutil.SectionBlock).Text.Text; heading != "*Showing 2 of 312 instances*" {
+			t.Errorf("unexpected heading %q", heading)
+		}
+
+		expected := "```\n" +
+			"ID                   NAME          STATE    ACCOUNT                 PRIVATE IP\n" +
+			"i-0123456789abcdef0  api-worker-1  running  PRODUCTION / eu-west-2  10.1.2.3\n" +
+			"i-0fedcba9876543210  -             running  PRODUCTION / eu-west-2  10.1.2.3\n" +
+			"```"
+		if table := blocks[1].(slackutil.SectionBlock).Text.Text; table != expected {
+			t.Errorf("unexpected table\n%s", table)
+		}
+	})
+
+	t.Run("It splits big tables so that slack will accept them", func(t *testing.T) {
+		results := []search.Result{}
+		for i := 0; i < 50; i++ {
+			results = append(results, instance("i-0123456789abcdef0", "api-worker-with-a-really-long-name"))
+		}
+
+		blocks := FormatEc2InstancesAsList(search.ResultSet{Kind: "ec2.instance", Results: results, Total: 50})
+
+		if len(blocks) < 3 {
+			t.Errorf("expected the table to be split, got %d blocks", len(blocks))
+		}
+
+		if err := slackutil.ValidateBlocks(blocks); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestFormatResults(t *testing.T) {
+	t.Run("It never returns more blocks than slack allows", func(t *testing.T) {
+		addresses := []search.Result{}
+		for i := 0; i < 50; i++ {
+			addresses = append(addresses, search.Result{
+				Kind:     "ec2.elastic_ip",
+				Metadata: map[string][]string{"public_ip": []string{"3.8.1.2"}},
+			})
+		}
+
+		response := FormatResults("3.8.1.2", true, search.Results{
+			Sets:     []search.ResultSet{search.ResultSet{Kind: "ec2.elastic_ip", Results: addresses, Total: 50}},
+			Accounts: []search.AccountStatus{search.AccountStatus{Alias: "PRODUCTION", State: search.AccountOK}},
+		}, nil)
+
+		if err := response.Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestFormatNoResults(t *testing.T) {
+	t.Run("It escapes the query", func(t *testing.T) {
+		for _, recognised := range []bool{true, false} {
+			blocks := FormatNoResults("<!channel> & <@U060R4BJ4>", recognised, nil)
+
+			text := blocks[0].(slackutil.SectionBlock).Text.Text
+			if !strings.Contains(text, "`&lt;!channel&gt; &amp; &lt;@U060R4BJ4&gt;`") {
+				t.Errorf("expected the query to be escaped, got %q", text)
+			}
+		}
+	})
+}
+
+func TestFormatIdentifierSummary(t *testing.T) {
+	instance := search.Result{
+		Kind:         "ec2.instance",
+		AccountAlias: "PRODUCTION",
+		Region:       "eu-west-2",
+		Metadata: map[string][]string{
+			"instance_id":    []string{"i-0123456789abcdef0"},
+			"instance_state": []string{"running"},
+			"instance_type":  []string{"t3.micro"},
+		},
+		Links: map[string]string{"ec2_console": "https://console.aws.amazon.com"},
+	}
+
+	response := FormatIdentifierSummary([]identifierResults{
+		{
+			identifier: search.Identifier{Type: search.IdentifierEc2InstanceID, Value: "i-0123456789abcdef0"},
+			results: search.Results{
+				Sets: []search.ResultSet{{Kind: "ec2.instance", Results: []search.Result{instance}, Total: 1}},
+				Accounts: []search.AccountStatus{
+					{Alias: "PRODUCTION", State: search.AccountOK},
+					{Alias: "STAGING", State: search.AccountOK},
+				},
+			},
+		},
+		{
+			identifier: search.Identifier{Type: search.IdentifierPrivateIP, Value: "10.1.2.3"},
+			results: search.Results{
+				Accounts: []search.AccountStatus{
+					{Alias: "PRODUCTION", State: search.AccountOK},
+					{Alias: "STAGING", State: search.AccountError, Reason: "AccessDenied"},
+				},
+			},
+		},
+	}, 2)
+
+	if err := response.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"*Looked up 2 things mentioned in this message* (2 more were left out)",
+		"`i-0123456789abcdef0`\n• <https://console.aws.amazon.com|i-0123456789abcdef0> is a `running` `t3.micro` in PRODUCTION / eu-west-2",
+		"`10.1.2.3`\n🤷 nothing found",
+	}
+	if len(response.Blocks) != len(expected)+1 {
+		t.Fatalf("expected %d blocks, got %d", len(expected)+1, len(response.Blocks))
+	}
+
+	for i, text := range expected {
+		if actual := response.Blocks[i].(slackutil.SectionBlock).Text.Text; actual != text {
+			t.Errorf("block %d: expected %q, got %q", i, text, actual)
+		}
+	}
+
+	footer := response.Blocks[len(expected)].(slackutil.ContextBlock).Elements[0].Text
+	if footer != "searched 2 accounts, 1 failed: STAGING (AccessDenied)" {
+		t.Errorf("unexpected footer %q", footer)
+	}
+}
+
+func TestFormatHelp(t *testing.T) {
+	response := FormatHelp(
+		[]search.Description{
+			{Name: "EC2 instances", Examples: []search.Example{{Description: "an instance ID", Query: "i-0123456789abcdef0"}}},
+			{Name: "RDS databases", Examples: []search.Example{{Description: "a database name", Query: "db:orders"}}},
+		},
+		[]search.Account{
+			{Alias: "PRODUCTION", Region: "eu-west-2"},
+			{Alias: "STAGING", Region: "eu-west-2"},
+			{Alias: "PRODUCTION", Region: "us-east-1"},
+		},
+	)
+
+	if err := response.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sections := []string{}
+	for _, block := range response.Blocks {
+		sections = append(sections, block.(slackutil.SectionBlock).Text.Text)
+	}
+	text := strings.Join(sections, "\n")
+
+	expected := []string{
+		"*EC2 instances*",
+		"an instance ID, e.g. `i-0123456789abcdef0`",
+		"*RDS databases*",
+		"a database name, e.g. `db:orders`",
+		"`PRODUCTION` in eu-west-2, us-east-1",
+		"`STAGING` in eu-west-2",
+		"`--account=&lt;alias&gt;`",
+	}
+	for _, e := range expected {
+		if !strings.Contains(text, e) {
+			t.Errorf("expected help to contain %q, got %s", e, text)
+		}
+	}
+}
@@ -3,27 +3,96 @@ package http
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"time"
 
+	bugsnag "github.com/bugsnag/bugsnag-go"
+	"github.com/geckoboard/slash-infra/audit"
+	"github.com/geckoboard/slash-infra/command"
+	"github.com/geckoboard/slash-infra/policy"
 	"github.com/geckoboard/slash-infra/search"
 	"github.com/geckoboard/slash-infra/slackutil"
 	"github.com/julienschmidt/httprouter"
 )
 
+// The request URLs to configure in the slack app. Requests that arrive over
+// Socket Mode are sent to the same paths
+const (
+	slashCommandPath = "/slack/infra-search"
+	interactionsPath = "/slack/interactions"
+	eventsPath       = "/slack/events"
+)
+
 func makeHttpHandler() *httprouter.Router {
 	router := httprouter.New()
 
+	ec2 := search.NewEc2()
+
 	s := httpServer{
-		ec2Resolver: search.NewEc2(),
+		resolvers: search.NewRegistry(
+			ec2,
+		),
+		interactions: slackutil.NewInteractionRouter(),
+		events:       slackutil.NewEventRouter(),
+		audit:        audit.FromEnvironment(),
+	}
+
+	if token := os.Getenv("SLACK_BOT_TOKEN"); token != "" {
+		s.slack = slackutil.NewClient(token)
+		s.policy = policy.FromEnvironment(s.slack)
+	} else {
+		// User groups can't be checked without a token
+		s.policy = policy.FromEnvironment(nil)
+	}
+
+	// Rules are set per alias, so another alias for the same account mustn't
+	// let people get around them
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := s.policy.CheckAccounts(ctx, ec2); err != nil {
+		log.Fatal(err)
 	}
+	cancel()
 
-	router.POST("/slack/infra-search", s.whatIsHandler)
+	registerInstanceActions(s.interactions, ec2, s.policy, s.audit)
+	registerShortcuts(s.interactions, s)
+
+	if s.slack == nil {
+		log.Print("SLACK_BOT_TOKEN is not set, so events (e.g. mentions of the bot) will be ignored")
+	} else {
+		s.events.HandleEvent(slackutil.EventAppMention, s.appMentionHandler)
+		s.events.HandleEvent(slackutil.EventLinkShared, s.linkSharedHandler)
+		s.events.HandleEvent(slackutil.EventMessage, s.messageHandler)
+	}
+
+	router.POST(slashCommandPath, s.whatIsHandler)
+
+	// Slack sends every block_actions and interactive_message payload to
+	// this one URL. Like every other route, it's protected by the request
+	// signature check wrapped around the router
+	router.Handler("POST", interactionsPath, s.interactions)
+
+	// The Events API request URL, which also has to answer slack's
+	// url_verification challenge
+	router.Handler("POST", eventsPath, s.events)
 
 	return router
 }
 
 type httpServer struct {
-	ec2Resolver *search.EC2Resolver
+	resolvers    *search.Registry
+	interactions *slackutil.InteractionRouter
+	events       *slackutil.EventRouter
+
+	// Nil if we don't have a bot token
+	slack *slackutil.Client
+
+	// Decides which accounts each search may touch
+	policy *policy.Policy
+
+	// Records who ran each command, and what it found
+	audit *audit.Logger
 }
 
 func respondWithError(w http.ResponseWriter, statusCode int, msg string) {
@@ -31,86 +100,139 @@ func respondWithError(w http.ResponseWriter, statusCode int, msg string) {
 	w.Write([]byte(msg))
 }
 
-func FormatEc2InstanceAsAttachment(instance search.Result) slackutil.Attachment {
-	fields := []slackutil.Field{
-		slackutil.Field{
-			Title: "Environment",
-			Value: instance.GetMetadata("tag:Environment"),
-			Short: true,
-		},
-		slackutil.Field{
-			Title: "Role",
-			Value: instance.GetMetadata("tag:Role"),
-			Short: true,
-		},
+func (h httpServer) whatIsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	slashCommand, err := slackutil.ParseSlashCommandRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "could not parse payload")
+		return
 	}
 
-	if publicIps := instance.GetMetadata("public_ips"); publicIps != "" {
-		fields = append(fields, slackutil.Field{
-			Title: "Public IP(s)",
-			Value: instance.GetMetadata("public_ips"),
-			Short: true,
-		})
-	}
-	if privateIps := instance.GetMetadata("private_ips"); privateIps != "" {
-		fields = append(fields, slackutil.Field{
-			Title: "Private IP(s)",
-			Value: privateIps,
-			Short: true,
-		})
-	}
-	fields = append(fields, slackutil.Field{
-		Value: fmt.Sprintf("⏳ <%s|AWS config timeline>", instance.GetLink("config_timeline")),
-	})
-
-	return slackutil.Attachment{
-		Text: fmt.Sprintf(
-			"Instance <%s|%s> is a `%s` `%s` in `%s`",
-			instance.GetLink("ec2_console"),
-			instance.GetMetadata("instance_id"),
-			instance.GetMetadata("instance_state"),
-			instance.GetMetadata("instance_type"),
-			instance.GetMetadata("az"),
-		),
-		Fields:     fields,
-		MarkdownIn: []string{"text"},
-	}
-}
+	record := audit.NewRecord(audit.SourceSlashCommand)
+	record.TeamID = slashCommand.TeamID
+	record.UserID = slashCommand.UserID
+	record.UserName = slashCommand.UserName
+	record.ChannelID = slashCommand.ChannelID
+	record.ChannelName = slashCommand.ChannelName
+	record.Command = slashCommand.Command
+	record.Text = slashCommand.Text
 
-func (h httpServer) whatIsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	command, err := slackutil.ParseSlashCommandRequest(r)
+	cmd, err := command.Parse(slashCommand.Text)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "could not parse payload")
+		record.AddError(err)
+		record.Finish()
+		h.audit.Log(record)
+
+		response := formatCommandError(err)
+		response.ResponseType = slackutil.ResponseEphemeral
+		slackutil.RespondWith(w, response)
 		return
 	}
+	record.Subcommand = cmd.Name
 
-	findResources := slackutil.DelayedSlashResponse{
-		PendingResponse: slackutil.Response{
-			Text: "Hang on a jiffy while we look that up...",
-		},
+	// Only searches are shared with the channel, and only if the user
+	// didn't ask for them to be private. Results from an account the user may
+	// only search because of who they are, not where, are never shared
+	public := cmd.Name == command.Search && !cmd.Private
 
+	findResources := slackutil.DelayedSlashResponse{
 		Handler: func(ctx context.Context, req slackutil.SlashCommandRequest, resp slackutil.MessageResponder) {
-			resultSets := h.ec2Resolver.Search(ctx, command.Text)
+			ctx = search.WithProgress(ctx, func(progress search.Progress) {
+				if progress.Searched > 0 {
+					resp.Progress(fmt.Sprintf("Hang on a jiffy, searched %d/%d accounts...", progress.Searched, progress.Total))
+				}
+			})
 
-			response := slackutil.Response{
-				Attachments: []slackutil.Attachment{},
-			}
+			ctx = audit.WithRecord(ctx, record)
 
-			for _, setOfResults := range resultSets {
-				if setOfResults.Kind == "ec2.instance" {
-					if len(setOfResults.Results) == 1 {
-						response.Attachments = append(response.Attachments, FormatEc2InstanceAsAttachment(setOfResults.Results[0]))
-					}
-				}
+			response, personal := h.runCommand(ctx, requestFromSlashCommand(req), cmd)
 
+			var err error
+			if public && !personal {
+				err = resp.PublicResponse(response)
+			} else {
+				err = resp.EphemeralResponse(response)
 			}
 
-			resp.PublicResponse(response)
-
+			record.AddError(err)
+			record.Finish()
+			h.audit.Log(record)
 		},
 
-		ShowSlashCommandInChannel: true,
+		ShowSlashCommandInChannel: public,
+		Client:                    h.slack,
+	}
+
+	if cmd.Name == command.Search {
+		findResources.PendingResponse = slackutil.Response{
+			Text: "Hang on a jiffy while we look that up...",
+		}
+	}
+
+	findResources.Run(w, *slashCommand)
+}
+
+// runCommand runs a parsed command on behalf of someone, and formats its
+// response. It also reports whether the response must only be shown to them
+func (h httpServer) runCommand(ctx context.Context, req policy.Request, cmd *command.Command) (slackutil.Response, bool) {
+	switch cmd.Name {
+	case command.Help:
+		return FormatHelp(h.resolvers.Describe(), h.resolvers.Accounts()), false
+
+	case command.Accounts:
+		return FormatAccounts(h.resolvers.Accounts()), false
+
+	default:
+		if err := cmd.Validate(h.resolvers.Accounts()); err != nil {
+			audit.FromContext(ctx).AddError(err)
+			return formatCommandError(err), false
+		}
+
+		return h.search(ctx, req, cmd.Query)
 	}
+}
+
+// search runs a query through the resolvers and formats the results. It also
+// reports whether the results must only be shown to the user
+func (h httpServer) search(ctx context.Context, req policy.Request, query search.Query) (slackutil.Response, bool) {
+	results, personal, err := h.searchFor(ctx, req, query)
+	if err != nil {
+		audit.FromContext(ctx).AddError(err)
+		return formatCommandError(err), false
+	}
+
+	response := FormatResults(query.Text, h.resolvers.CanHandle(query.Text), results, h.resolvers.Examples())
+	if err := response.Validate(); err != nil {
+		bugsnag.Notify(err)
+		response = slackutil.Response{
+			Text: fmt.Sprintf("Sorry, I found some results but couldn't show them: %s", err),
+		}
+	}
+
+	return response, personal
+}
+
+// searchFor runs a query through the resolvers, only searching the accounts
+// the request is allowed to. The accounts that were left out are included in
+// the results, so they can be mentioned. The search is added to the audit
+// record in ctx, if there is one.
+//
+// It also reports whether any of the accounts searched were only allowed
+// because of who the user is, rather than where they are, in which case the
+// results must only be shown to them
+func (h httpServer) searchFor(ctx context.Context, req policy.Request, query search.Query) (search.Results, bool, error) {
+	query, denied, personal, err := h.policy.Restrict(ctx, req, query, h.resolvers.Accounts())
+	if err != nil {
+		return search.Results{}, false, err
+	}
+
+	results := h.resolvers.Search(ctx, query)
+	results.Accounts = append(results.Accounts, denied...)
+
+	audit.FromContext(ctx).AddSearch(query, h.resolvers.ResolversFor(query.Text), results)
+
+	return results, len(personal) > 0, nil
+}
 
-	findResources.Run(w, *command)
+func requestFromSlashCommand(req slackutil.SlashCommandRequest) policy.Request {
+	return policy.Request{TeamID: req.TeamID, ChannelID: req.ChannelID, UserID: req.UserID}
 }
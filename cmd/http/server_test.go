@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geckoboard/slash-infra/audit"
+	"github.com/geckoboard/slash-infra/command"
+	"github.com/geckoboard/slash-infra/policy"
+	"github.com/geckoboard/slash-infra/search"
+)
+
+// fakeResolver pretends to search some accounts, without finding anything
+type fakeResolver struct {
+	accounts []string
+}
+
+func (f fakeResolver) CanHandle(query string) bool {
+	return true
+}
+
+func (f fakeResolver) Search(ctx context.Context, query search.Query) search.Results {
+	results := search.Results{}
+
+	for _, alias := range f.accounts {
+		if query.IncludesAccount(alias) {
+			results.Accounts = append(results.Accounts, search.AccountStatus{Alias: alias, State: search.AccountOK})
+		}
+	}
+
+	return results
+}
+
+func (f fakeResolver) Accounts() []search.Account {
+	accounts := []search.Account{}
+	for _, alias := range f.accounts {
+		accounts = append(accounts, search.Account{Alias: alias, Region: "eu-west-2"})
+	}
+
+	return accounts
+}
+
+func TestRunCommand(t *testing.T) {
+	h := httpServer{
+		resolvers: search.NewRegistry(fakeResolver{accounts: []string{"DEV", "PRODUCTION"}}),
+		policy: policy.New(map[string]policy.Rule{
+			"PRODUCTION": {Channels: []string{"C0LAN2Q65"}, Users: []string{"U2CERLKJA"}},
+		}, nil),
+	}
+
+	cmd, err := command.Parse("i-0123456789abcdef0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("It keeps results private if the user is only allowed because of who they are", func(t *testing.T) {
+		record := audit.NewRecord(audit.SourceSlashCommand)
+		ctx := audit.WithRecord(context.Background(), record)
+
+		_, personal := h.runCommand(ctx, policy.Request{ChannelID: "G8PSS9T3V", UserID: "U2CERLKJA"}, cmd)
+		if !personal {
+			t.Error("expected the response to be private")
+		}
+
+		if len(record.Searches) != 1 || len(record.Searches[0].Accounts) != 2 {
+			t.Errorf("expected every account to be searched, got %#v", record.Searches)
+		}
+	})
+
+	t.Run("It shares results if the channel is allowed", func(t *testing.T) {
+		_, personal := h.runCommand(context.Background(), policy.Request{ChannelID: "C0LAN2Q65", UserID: "U2CERLKJA"}, cmd)
+		if personal {
+			t.Error("expected the response to be shareable")
+		}
+	})
+
+	t.Run("It shares results from accounts anyone can search", func(t *testing.T) {
+		scoped := cmd.Query
+		scoped.Accounts = []string{"DEV"}
+
+		_, personal := h.search(context.Background(), policy.Request{ChannelID: "G8PSS9T3V", UserID: "U2CERLKJA"}, scoped)
+		if personal {
+			t.Error("expected the response to be shareable")
+		}
+	})
+}
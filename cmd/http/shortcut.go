@@ -0,0 +1,136 @@
+package http
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+	"github.com/geckoboard/slash-infra/audit"
+	"github.com/geckoboard/slash-infra/policy"
+	"github.com/geckoboard/slash-infra/search"
+	"github.com/geckoboard/slash-infra/slackutil"
+)
+
+const (
+	// The callback ID of the "Look up infrastructure" message shortcut, as
+	// configured in the slack app
+	shortcutLookUpInfrastructure = "look_up_infrastructure"
+
+	// Alerts can mention a lot of things, so we only look up the first few
+	maxShortcutIdentifiers = 10
+)
+
+// identifierResults is what was found for one identifier in a message
+type identifierResults struct {
+	identifier search.Identifier
+	results    search.Results
+}
+
+func registerShortcuts(router *slackutil.InteractionRouter, h httpServer) {
+	router.HandleShortcut(shortcutLookUpInfrastructure, slackutil.DelayedActionResponse{
+		PendingResponse: slackutil.Response{
+			Text: "Hang on a jiffy while we look everything up...",
+		},
+		Handler: h.lookUpMessageHandler,
+	})
+}
+
+// lookUpMessageHandler searches for every identifier in a message, and
+// replies in a thread with a summary of what was found
+func (h httpServer) lookUpMessageHandler(ctx context.Context, payload slackutil.InteractionPayload, action slackutil.Action, resp slackutil.MessageResponder) {
+	if payload.Message == nil {
+		return
+	}
+
+	text := slackutil.PlainMessageText(payload.Message.Text)
+
+	record := audit.NewRecord(audit.SourceShortcut)
+	record.TeamID = payload.Team.ID
+	record.UserID = payload.User.ID
+	record.UserName = payload.User.Username
+	record.ChannelID = payload.Channel.ID
+	record.ChannelName = payload.Channel.Name
+	record.Command = shortcutLookUpInfrastructure
+	record.Text = text
+	ctx = audit.WithRecord(ctx, record)
+
+	defer func() {
+		record.Finish()
+		h.audit.Log(record)
+	}()
+
+	identifiers := search.FindIdentifiers(text)
+	if len(identifiers) == 0 {
+		resp.EphemeralResponse(slackutil.Response{
+			Text: "🤷 I couldn't see any instance IDs, IPs, hostnames or other IDs in that message",
+		})
+		return
+	}
+
+	omitted := 0
+	if len(identifiers) > maxShortcutIdentifiers {
+		omitted = len(identifiers) - maxShortcutIdentifiers
+		identifiers = identifiers[:maxShortcutIdentifiers]
+	}
+
+	req := policy.Request{TeamID: payload.Team.ID, ChannelID: payload.Channel.ID, UserID: payload.User.ID}
+	found := make([]identifierResults, len(identifiers))
+	personal := make([]bool, len(identifiers))
+	errs := make([]error, len(identifiers))
+
+	var wg sync.WaitGroup
+	for i, identifier := range identifiers {
+		wg.Add(1)
+		go func(i int, identifier search.Identifier) {
+			defer wg.Done()
+
+			results, isPersonal, err := h.searchFor(ctx, req, identifier.Query())
+			found[i] = identifierResults{identifier: identifier, results: results}
+			personal[i] = isPersonal
+			errs[i] = err
+		}(i, identifier)
+	}
+	wg.Wait()
+
+	// Every identifier is checked against the same accounts, so they're
+	// either all denied or none are
+	if errs[0] != nil {
+		record.AddError(errs[0])
+		resp.EphemeralResponse(formatCommandError(errs[0]))
+		return
+	}
+
+	response := FormatIdentifierSummary(found, omitted)
+
+	threadTS := payload.Message.ThreadTS
+	if threadTS == "" {
+		threadTS = payload.Message.TS
+	}
+
+	// Like the errors, every identifier is checked against the same
+	// accounts. If some of them can only be searched because of who the
+	// user is, the summary is only shown to them
+	if h.slack == nil || personal[0] {
+		resp.EphemeralResponse(response)
+		return
+	}
+
+	_, err := h.slack.PostMessage(ctx, slackutil.Message{
+		Channel:  payload.Channel.ID,
+		ThreadTS: threadTS,
+		Text:     response.Text,
+		Blocks:   response.Blocks,
+	})
+	if err != nil {
+		// The bot can't post in channels it hasn't been invited to, so we
+		// show the summary to the user instead
+		record.AddError(err)
+		log.Print(err)
+		if !slackutil.IsAPIError(err, "not_in_channel", "channel_not_found") {
+			bugsnag.Notify(err)
+		}
+
+		resp.EphemeralResponse(response)
+	}
+}
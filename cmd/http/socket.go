@@ -0,0 +1,49 @@
+package http
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/geckoboard/slash-infra/slackutil"
+	"github.com/spf13/cobra"
+)
+
+// SocketCommand runs the bot over Socket Mode, so that slack doesn't need a
+// public URL to send requests to
+func SocketCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "socket",
+		Short: "Run the slack bot over a Socket Mode websocket, without a public URL",
+		Run: func(cmd *cobra.Command, args []string) {
+			appToken := os.Getenv("SLACK_APP_TOKEN")
+			if appToken == "" {
+				log.Fatal("SLACK_APP_TOKEN must be set to an app-level token with the connections:write scope")
+			}
+
+			socketMode := &slackutil.SocketMode{
+				Client:           slackutil.NewClient(appToken),
+				Handler:          makeHttpHandler(),
+				SlashCommandPath: slashCommandPath,
+				InteractionsPath: interactionsPath,
+				EventsPath:       eventsPath,
+			}
+
+			// Close the connection cleanly when we're stopped, so that slack
+			// sends requests to any other connection straight away
+			ctx, cancel := context.WithCancel(context.Background())
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-signals
+				cancel()
+			}()
+
+			if err := socketMode.Run(ctx); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+}
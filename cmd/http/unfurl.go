@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+	"github.com/geckoboard/slash-infra/audit"
+	"github.com/geckoboard/slash-infra/policy"
+	"github.com/geckoboard/slash-infra/search"
+	"github.com/geckoboard/slash-infra/slackutil"
+)
+
+// We reply to messages that mention instance IDs without being asked to, so
+// only look up the first few to keep the reply short
+const maxPreviewedInstanceIDs = 3
+
+// linkSharedHandler adds previews to links to instances and IPs in the AWS
+// console
+func (h httpServer) linkSharedHandler(ctx context.Context, envelope slackutil.EventEnvelope) {
+	event := envelope.Event
+	unfurls := map[string]slackutil.Unfurl{}
+
+	resources := map[string]search.ConsoleResource{}
+	for _, link := range event.Links {
+		if resource, ok := search.ParseConsoleURL(link.URL); ok {
+			resources[link.URL] = resource
+		}
+	}
+
+	// Links to anything else aren't looked up, so aren't audited
+	if len(resources) == 0 {
+		return
+	}
+
+	links := []string{}
+	for link := range resources {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+
+	record := eventRecord(audit.SourceUnfurl, envelope, strings.Join(links, " "))
+	ctx = audit.WithRecord(ctx, record)
+
+	defer func() {
+		record.Finish()
+		h.audit.Log(record)
+	}()
+
+	for _, link := range links {
+		resource := resources[link]
+		if result, ok := h.lookup(ctx, requestFromEvent(envelope), resource.ID, resource.Region, resource.ResourceType); ok {
+			unfurls[link] = slackutil.Unfurl{Blocks: FormatResultPreview(result)}
+		}
+	}
+
+	if len(unfurls) == 0 {
+		return
+	}
+
+	err := h.slack.Unfurl(ctx, slackutil.UnfurlRequest{
+		Channel:  event.Channel,
+		TS:       event.MessageTS,
+		UnfurlID: event.UnfurlID,
+		Source:   event.Source,
+		Unfurls:  unfurls,
+	})
+	if err != nil {
+		record.AddError(err)
+		log.Print(err)
+		bugsnag.Notify(err)
+	}
+}
+
+// messageHandler replies in a thread to messages that mention instance IDs,
+// with a preview of each instance. Nothing is posted if none of them are
+// found
+func (h httpServer) messageHandler(ctx context.Context, envelope slackutil.EventEnvelope) {
+	event := envelope.Event
+
+	// Edits, joins etc. have a subtype, and we don't want to reply to bots
+	// (including ourselves). Messages that mention the bot are handled as
+	// app_mention events
+	if event.Subtype != "" || event.BotID != "" {
+		return
+	}
+	if botUserID := envelope.BotUserID(); botUserID != "" && strings.Contains(event.Text, "<@"+botUserID) {
+		return
+	}
+
+	// Links to the console are unfurled instead
+	ids := search.FindInstanceIDs(slackutil.WithoutLinks(event.Text))
+	if len(ids) == 0 {
+		return
+	}
+	if len(ids) > maxPreviewedInstanceIDs {
+		ids = ids[:maxPreviewedInstanceIDs]
+	}
+
+	record := eventRecord(audit.SourceMessage, envelope, slackutil.PlainMessageText(event.Text))
+	ctx = audit.WithRecord(ctx, record)
+
+	defer func() {
+		record.Finish()
+		h.audit.Log(record)
+	}()
+
+	found := make([]*search.Result, len(ids))
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+
+			if result, ok := h.lookup(ctx, requestFromEvent(envelope), id, "", "ec2.instance"); ok {
+				found[i] = &result
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	blocks := []slackutil.Block{}
+	foundIDs := []string{}
+	for i, result := range found {
+		if result != nil {
+			blocks = append(blocks, FormatResultPreview(*result)...)
+			foundIDs = append(foundIDs, ids[i])
+		}
+	}
+
+	if len(blocks) == 0 {
+		return
+	}
+
+	_, err := h.slack.PostMessage(ctx, slackutil.Message{
+		Channel:  event.Channel,
+		ThreadTS: event.ReplyThreadTS(),
+		Text:     fmt.Sprintf("Found %s", strings.Join(foundIDs, ", ")),
+		Blocks:   limitBlocks(blocks, slackutil.MaxBlocksPerMessage),
+	})
+	if err != nil {
+		record.AddError(err)
+		log.Print(err)
+		bugsnag.Notify(err)
+	}
+}
+
+// lookup finds the single result a query refers to, in the accounts the
+// request is allowed to search. If region is set, only results in that
+// region are considered, and results of the preferred kind are picked over
+// others.
+//
+// The result is shown to everyone without the user asking for it, so only
+// accounts that everyone in the channel may see are searched
+func (h httpServer) lookup(ctx context.Context, req policy.Request, query, region, preferredKind string) (search.Result, bool) {
+	req.Shared = true
+
+	results, _, err := h.searchFor(ctx, req, search.Query{Text: query})
+	if err != nil {
+		return search.Result{}, false
+	}
+	if region != "" {
+		results = results.InRegion(region)
+	}
+
+	var fallback *search.Result
+	for _, set := range results.Sets {
+		if len(set.Results) == 0 {
+			continue
+		}
+
+		if set.Kind == preferredKind {
+			return set.Results[0], true
+		}
+		if fallback == nil {
+			fallback = &set.Results[0]
+		}
+	}
+
+	if fallback == nil {
+		return search.Result{}, false
+	}
+
+	return *fallback, true
+}
@@ -0,0 +1,251 @@
+// Package command parses the text people type after /infra-search, or after
+// mentioning the bot, into a subcommand and its flags
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/geckoboard/slash-infra/search"
+)
+
+// Subcommands
+const (
+	Help     = "help"
+	Accounts = "accounts"
+	Search   = "search"
+)
+
+// Subcommands lists every subcommand, along with a short description
+var Subcommands = []Usage{
+	{Name: Search, Args: "[flags] <query>", Description: "search every account for the query. `search` can be left out"},
+	{Name: Accounts, Description: "list the accounts and regions that are searched"},
+	{Name: Help, Description: "show this help"},
+}
+
+// Flags lists every flag, along with a short description
+var Flags = []Usage{
+	{Name: "--account", Args: "=<alias>", Description: "only search this account. Can be given more than once, or as a comma separated list"},
+	{Name: "--region", Args: "=<region>", Description: "only search this region, e.g. `eu-west-2`"},
+	{Name: "--limit", Args: "=<n>", Description: "show at most this many results of each kind"},
+	{Name: "--private", Description: "only show the results to you"},
+}
+
+// Usage describes a subcommand or flag
+type Usage struct {
+	Name        string
+	Args        string
+	Description string
+}
+
+// Command is a parsed subcommand
+type Command struct {
+	Name string
+
+	// The query to search for, along with the accounts, regions and limit
+	// from the flags
+	Query search.Query
+
+	// Set if only the user who ran the command should see the response
+	Private bool
+}
+
+// UsageError explains what was wrong with a command, in a way that can be
+// shown to the user
+type UsageError struct {
+	Message string
+}
+
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &UsageError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Parse reads a command, e.g.
+//
+//	search --account=prod --region=eu-west-2 i-0123456789abcdef0
+//
+// If the text doesn't start with a subcommand it's treated as a search, so
+// `/infra-search 10.1.2.3` still works. Flags can go anywhere. Problems with
+// the command are returned as a *UsageError
+func Parse(text string) (*Command, error) {
+	words, err := Split(text)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := &Command{Name: Search}
+	if len(words) > 0 && isSubcommand(words[0]) {
+		cmd.Name = strings.ToLower(words[0])
+		words = words[1:]
+	}
+
+	args := []string{}
+
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+
+		// A bare -- means everything after it is part of the query, even if
+		// it looks like a flag
+		if word == "--" {
+			args = append(args, words[i+1:]...)
+			break
+		}
+
+		if !strings.HasPrefix(word, "--") {
+			args = append(args, word)
+			continue
+		}
+
+		name, value, hasValue := splitFlag(word)
+
+		switch name {
+		case "--private":
+			if hasValue {
+				return nil, usageErrorf("`--private` doesn't take a value")
+			}
+			cmd.Private = true
+			continue
+
+		case "--account", "--region", "--limit":
+			// Values can also be given as the next word, e.g. --account prod
+			if !hasValue {
+				if i+1 >= len(words) {
+					return nil, usageErrorf("`%s` needs a value, e.g. `%s=%s`", name, name, flagExample(name))
+				}
+				i++
+				value = words[i]
+			}
+
+		default:
+			return nil, usageErrorf("I don't know the flag `%s`", name)
+		}
+
+		if value == "" {
+			return nil, usageErrorf("`%s` needs a value, e.g. `%s=%s`", name, name, flagExample(name))
+		}
+
+		switch name {
+		case "--account":
+			cmd.Query.Accounts = append(cmd.Query.Accounts, splitList(value)...)
+		case "--region":
+			cmd.Query.Regions = append(cmd.Query.Regions, splitList(value)...)
+		case "--limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 {
+				return nil, usageErrorf("`--limit` should be a number greater than 0, not `%s`", value)
+			}
+			cmd.Query.Limit = limit
+		}
+	}
+
+	cmd.Query.Text = strings.TrimSpace(strings.Join(args, " "))
+
+	switch cmd.Name {
+	case Search:
+		if cmd.Query.Text == "" {
+			return nil, usageErrorf("tell me what to search for, e.g. `i-0123456789abcdef0`")
+		}
+	default:
+		if cmd.Query.Text != "" {
+			return nil, usageErrorf("`%s` doesn't take a query, did you mean `search %s`?", cmd.Name, cmd.Query.Text)
+		}
+	}
+
+	return cmd, nil
+}
+
+// Validate checks that the accounts and regions the command asks for are
+// ones we search
+func (c *Command) Validate(accounts []search.Account) error {
+	for _, alias := range c.Query.Accounts {
+		if !hasAccount(accounts, func(account search.Account) bool { return strings.EqualFold(account.Alias, alias) }) {
+			return usageErrorf("I don't search an account called `%s`. I know about %s", alias, listAliases(accounts))
+		}
+	}
+
+	for _, region := range c.Query.Regions {
+		if !hasAccount(accounts, func(account search.Account) bool { return strings.EqualFold(account.Region, region) }) {
+			return usageErrorf("I don't search any accounts in `%s`", region)
+		}
+	}
+
+	return nil
+}
+
+func hasAccount(accounts []search.Account, match func(search.Account) bool) bool {
+	for _, account := range accounts {
+		if match(account) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func listAliases(accounts []search.Account) string {
+	aliases := []string{}
+	seen := map[string]bool{}
+
+	for _, account := range accounts {
+		if !seen[account.Alias] {
+			seen[account.Alias] = true
+			aliases = append(aliases, fmt.Sprintf("`%s`", account.Alias))
+		}
+	}
+
+	if len(aliases) == 0 {
+		return "no accounts"
+	}
+
+	return strings.Join(aliases, ", ")
+}
+
+func isSubcommand(word string) bool {
+	for _, subcommand := range Subcommands {
+		if strings.EqualFold(word, subcommand.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitFlag splits --name=value into its name and value
+func splitFlag(word string) (string, string, bool) {
+	parts := strings.SplitN(word, "=", 2)
+	name := strings.ToLower(parts[0])
+
+	if len(parts) == 1 {
+		return name, "", false
+	}
+
+	return name, parts[1], true
+}
+
+func splitList(value string) []string {
+	values := []string{}
+
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func flagExample(name string) string {
+	switch name {
+	case "--account":
+		return "production"
+	case "--region":
+		return "eu-west-2"
+	default:
+		return "20"
+	}
+}
@@ -0,0 +1,105 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/geckoboard/slash-infra/search"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected Command
+	}{
+		{
+			"i-0123456789abcdef0",
+			Command{Name: Search, Query: search.Query{Text: "i-0123456789abcdef0"}},
+		},
+		{
+			"search --account=prod,staging --region eu-west-2 --limit=20 --private name:api-*",
+			Command{
+				Name: Search,
+				Query: search.Query{
+					Text:     "name:api-*",
+					Accounts: []string{"prod", "staging"},
+					Regions:  []string{"eu-west-2"},
+					Limit:    20,
+				},
+				Private: true,
+			},
+		},
+		{
+			`10.1.2.3 --account=prod --account dev`,
+			Command{Name: Search, Query: search.Query{Text: "10.1.2.3", Accounts: []string{"prod", "dev"}}},
+		},
+		{
+			`search -- --private`,
+			Command{Name: Search, Query: search.Query{Text: "--private"}},
+		},
+		{"HELP", Command{Name: Help}},
+		{"accounts", Command{Name: Accounts}},
+	}
+
+	for _, c := range cases {
+		actual, err := Parse(c.text)
+		if err != nil {
+			t.Errorf("Parse(%q) returned %s", c.text, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(*actual, c.expected) {
+			t.Errorf("Parse(%q) = %#v, expected %#v", c.text, *actual, c.expected)
+		}
+	}
+}
+
+func TestParseUsageErrors(t *testing.T) {
+	cases := map[string]string{
+		"":                       "tell me what to search for, e.g. `i-0123456789abcdef0`",
+		"search --private":       "tell me what to search for, e.g. `i-0123456789abcdef0`",
+		"--colour=red 10.1.2.3":  "I don't know the flag `--colour`",
+		"10.1.2.3 --account":     "`--account` needs a value, e.g. `--account=production`",
+		"10.1.2.3 --region=":     "`--region` needs a value, e.g. `--region=eu-west-2`",
+		"10.1.2.3 --limit=lots":  "`--limit` should be a number greater than 0, not `lots`",
+		"10.1.2.3 --private=yes": "`--private` doesn't take a value",
+		"accounts 10.1.2.3":      "`accounts` doesn't take a query, did you mean `search 10.1.2.3`?",
+		`name:"api`:              "there's a \" without a matching \"",
+	}
+
+	for text, expected := range cases {
+		_, err := Parse(text)
+
+		usageErr, ok := err.(*UsageError)
+		if !ok {
+			t.Errorf("Parse(%q) returned %#v, expected a usage error", text, err)
+			continue
+		}
+
+		if usageErr.Message != expected {
+			t.Errorf("Parse(%q) returned %q, expected %q", text, usageErr.Message, expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	accounts := []search.Account{
+		{Alias: "PRODUCTION", Region: "eu-west-2"},
+		{Alias: "STAGING", Region: "eu-west-2"},
+	}
+
+	valid := &Command{Name: Search, Query: search.Query{Accounts: []string{"production"}, Regions: []string{"eu-west-2"}}}
+	if err := valid.Validate(accounts); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	unknownAccount := &Command{Name: Search, Query: search.Query{Accounts: []string{"prod"}}}
+	if err := unknownAccount.Validate(accounts); err == nil || err.Error() != "I don't search an account called `prod`. I know about `PRODUCTION`, `STAGING`" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	unknownRegion := &Command{Name: Search, Query: search.Query{Regions: []string{"us-east-1"}}}
+	if err := unknownRegion.Validate(accounts); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Some slack clients replace quotes with "smart" quotes, and -- with a dash,
+// as they're typed, so we treat them like what they replaced
+var smartQuotes = strings.NewReplacer("“", `"`, "”", `"`, "‘", "'", "’", "'", "—", "--")
+
+// Split breaks text in to words like a shell would. Words are separated by
+// whitespace, unless it's quoted with single or double quotes or escaped
+// with a backslash
+func Split(text string) ([]string, error) {
+	words := []string{}
+
+	var (
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range smartQuotes.Replace(text) {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, usageErrorf("there's a %c without a matching %c", quote, quote)
+	}
+	if escaped {
+		return nil, usageErrorf("there's a \\ with nothing after it")
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
@@ -0,0 +1,36 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	cases := map[string][]string{
+		"":                                 []string{},
+		"  i-0123456789abcdef0  ":          []string{"i-0123456789abcdef0"},
+		`name:"api worker" --account=prod`: []string{"name:api worker", "--account=prod"},
+		`'it''s' "a \"quoted\" \\ word"`:   []string{"its", `a "quoted" \ word`},
+		`name:api\ worker '\'`:             []string{"name:api worker", `\`},
+		"“smart quotes” — ‘too’":           []string{"smart quotes", "--", "too"},
+		`"" x`:                             []string{"", "x"},
+	}
+
+	for text, expected := range cases {
+		actual, err := Split(text)
+		if err != nil {
+			t.Errorf("Split(%q) returned %s", text, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Split(%q) = %#v, expected %#v", text, actual, expected)
+		}
+	}
+
+	for _, invalid := range []string{`"unterminated`, `it's`, `trailing\`} {
+		if _, err := Split(invalid); err == nil {
+			t.Errorf("expected Split(%q) to fail", invalid)
+		}
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/bugsnag/bugsnag-go v1.4.0
 	github.com/bugsnag/panicwrap v1.2.1-0.20180510051541-1d162ee1264c // indirect
 	github.com/gofrs/uuid v3.3.0+incompatible // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
 	github.com/joho/godotenv v1.3.1-0.20181120194748-69ed1d913aa8
 	github.com/julienschmidt/httprouter v1.2.1-0.20181021223831-26a05976f9bf
@@ -23,6 +23,7 @@ func main() {
 	godotenv.Load()
 
 	rootCmd.AddCommand(http.Command())
+	rootCmd.AddCommand(http.SocketCommand())
 
 	rootCmd.Execute()
 }
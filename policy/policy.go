@@ -0,0 +1,354 @@
+// Package policy decides which accounts someone may search, based on where
+// they're searching from and who they are. It's checked before any resolver
+// runs, so that results from an account can't leak in to a channel they
+// shouldn't be shown in
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+	"github.com/geckoboard/slash-infra/search"
+)
+
+const (
+	// Prefixes of the environment variables that restrict who can search an
+	// account, e.g. ALLOW_CHANNELS_PRODUCTION=C0123ABCD,C0456EFGH
+	EnvVarPrefixForChannels   = "ALLOW_CHANNELS_"
+	EnvVarPrefixForUsers      = "ALLOW_USERS_"
+	EnvVarPrefixForUserGroups = "ALLOW_USERGROUPS_"
+	EnvVarPrefixForTeams      = "ALLOW_TEAMS_"
+
+	// How long we remember who is in a user group
+	userGroupCacheTTL = 5 * time.Minute
+)
+
+// Request is who is searching, and where from
+type Request struct {
+	TeamID    string
+	ChannelID string
+	UserID    string
+
+	// Set if the results will be shown to everyone in the channel without
+	// the user choosing to share them (e.g. link previews), in which case
+	// only the channel can allow an account, not who the user is
+	Shared bool
+}
+
+// Rule restricts who can search an account. Searches are allowed in any of
+// the channels, or by any of the users or members of the user groups. If
+// Teams is set, the search must also come from one of those workspaces
+type Rule struct {
+	Channels   []string
+	Users      []string
+	UserGroups []string
+	Teams      []string
+}
+
+// restrictsWho reports whether the rule limits which channels or users can
+// search, rather than only which workspaces
+func (r Rule) restrictsWho() bool {
+	return len(r.Channels) > 0 || len(r.Users) > 0 || len(r.UserGroups) > 0
+}
+
+// equal reports whether two rules allow the same requests
+func (r Rule) equal(other Rule) bool {
+	return reflect.DeepEqual(r.sorted(), other.sorted())
+}
+
+func (r Rule) sorted() [][]string {
+	lists := [][]string{}
+
+	for _, ids := range [][]string{r.Channels, r.Users, r.UserGroups, r.Teams} {
+		sorted := append([]string{}, ids...)
+		sort.Strings(sorted)
+		lists = append(lists, sorted)
+	}
+
+	return lists
+}
+
+// UserGroupLister looks up the members of a slack user group
+type UserGroupLister interface {
+	UserGroupMembers(ctx context.Context, userGroupID string) ([]string, error)
+}
+
+// AccountIDLister looks up the ID of the AWS account behind each alias
+type AccountIDLister interface {
+	AccountIDs(ctx context.Context) map[string]string
+}
+
+// DeniedError is returned when someone explicitly asks to search an account
+// they aren't allowed to search
+type DeniedError struct {
+	// Empty if they aren't allowed to search any account
+	Aliases []string
+}
+
+func (e *DeniedError) Error() string {
+	if len(e.Aliases) == 0 {
+		return "you're not allowed to search any accounts here"
+	}
+
+	return fmt.Sprintf("you're not allowed to search %s here", strings.Join(e.Aliases, ", "))
+}
+
+// Policy maps account aliases to the rule that restricts them. Accounts
+// without a rule can be searched by anyone, from anywhere
+type Policy struct {
+	rules      map[string]Rule
+	userGroups UserGroupLister
+
+	mu           sync.Mutex
+	groupMembers map[string]userGroupMembers
+	now          func() time.Time
+}
+
+type userGroupMembers struct {
+	users   map[string]bool
+	fetched time.Time
+}
+
+// New builds a policy from rules keyed by account alias, which is matched
+// ignoring case. userGroups can be nil if no rule uses user groups
+func New(rules map[string]Rule, userGroups UserGroupLister) *Policy {
+	normalised := map[string]Rule{}
+	for alias, rule := range rules {
+		normalised[strings.ToUpper(alias)] = rule
+	}
+
+	return &Policy{
+		rules:        normalised,
+		userGroups:   userGroups,
+		groupMembers: map[string]userGroupMembers{},
+		now:          time.Now,
+	}
+}
+
+// FromEnvironment builds a policy from environment variables named after
+// each account alias:
+//
+// `ALLOW_CHANNELS_{account alias}` - IDs of the channels the account can be
+// searched from
+//
+// `ALLOW_USERS_{account alias}` - IDs of the users who can search the
+// account from anywhere, although only they will see the results
+//
+// `ALLOW_USERGROUPS_{account alias}` - IDs of user groups whose members can
+// search the account from anywhere, although only they will see the results
+//
+// `ALLOW_TEAMS_{account alias}` - IDs of the workspaces the account can be
+// searched from
+//
+// Each variable is a comma separated list.
+func FromEnvironment(userGroups UserGroupLister) *Policy {
+	rules := map[string]Rule{}
+
+	fields := map[string]func(rule *Rule, ids []string){
+		EnvVarPrefixForChannels:   func(rule *Rule, ids []string) { rule.Channels = ids },
+		EnvVarPrefixForUsers:      func(rule *Rule, ids []string) { rule.Users = ids },
+		EnvVarPrefixForUserGroups: func(rule *Rule, ids []string) { rule.UserGroups = ids },
+		EnvVarPrefixForTeams:      func(rule *Rule, ids []string) { rule.Teams = ids },
+	}
+
+	for _, pair := range os.Environ() {
+		parts := strings.SplitN(pair, "=", 2)
+
+		for prefix, set := range fields {
+			if !strings.HasPrefix(parts[0], prefix) {
+				continue
+			}
+
+			alias := parts[0][len(prefix):]
+			rule := rules[alias]
+			set(&rule, splitIDs(parts[1]))
+			rules[alias] = rule
+		}
+	}
+
+	return New(rules, userGroups)
+}
+
+func splitIDs(value string) []string {
+	ids := []string{}
+
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// CheckAccounts makes sure that rules can't be sidestepped by searching an
+// account under another alias. Aliases for the same AWS account (e.g. one per
+// region) must all have the same rules, or none. Account IDs are only looked
+// up if there are any rules
+func (p *Policy) CheckAccounts(ctx context.Context, accounts AccountIDLister) error {
+	if len(p.rules) == 0 {
+		return nil
+	}
+
+	aliasesByID := map[string][]string{}
+	for alias, id := range accounts.AccountIDs(ctx) {
+		if id == "" {
+			return fmt.Errorf("could not find the account ID of %s, so can't check whether it shares rules with other aliases", alias)
+		}
+
+		aliasesByID[id] = append(aliasesByID[id], strings.ToUpper(alias))
+	}
+
+	for id, aliases := range aliasesByID {
+		sort.Strings(aliases)
+
+		for _, alias := range aliases[1:] {
+			if !p.rules[alias].equal(p.rules[aliases[0]]) {
+				return fmt.Errorf("%s and %s are both account %s, so must have the same ALLOW_ rules", aliases[0], alias, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Allows reports whether the request may search an account. Shared requests
+// are only allowed by the channel they're from
+func (p *Policy) Allows(ctx context.Context, req Request, alias string) bool {
+	rule, ok := p.rules[strings.ToUpper(alias)]
+	if !ok {
+		return true
+	}
+
+	if len(rule.Teams) > 0 && !contains(rule.Teams, req.TeamID) {
+		return false
+	}
+
+	if !rule.restrictsWho() || contains(rule.Channels, req.ChannelID) {
+		return true
+	}
+
+	if req.Shared {
+		return false
+	}
+
+	if contains(rule.Users, req.UserID) {
+		return true
+	}
+
+	for _, group := range rule.UserGroups {
+		if p.inUserGroup(ctx, group, req.UserID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsEveryone reports whether everyone in the request's channel may see
+// results from an account, rather than only the user because of who they are
+func (p *Policy) AllowsEveryone(ctx context.Context, req Request, alias string) bool {
+	req.Shared = true
+	return p.Allows(ctx, req, alias)
+}
+
+// Restrict narrows a query down to the accounts the request may search.
+//
+// If the query asked for particular accounts and any of them aren't allowed,
+// a DeniedError is returned. Otherwise the accounts that were left out are
+// returned with the AccountDenied state, so they can be mentioned alongside
+// the results. The aliases of accounts that were only allowed because of who
+// the user is are also returned, as their results mustn't be shown to anyone
+// else in the channel
+func (p *Policy) Restrict(ctx context.Context, req Request, query search.Query, accounts []search.Account) (search.Query, []search.AccountStatus, []string, error) {
+	allowed := []string{}
+	personal := []string{}
+	denied := []search.AccountStatus{}
+	deniedAliases := []string{}
+	checked := map[string]bool{}
+
+	for _, account := range accounts {
+		alias := account.Alias
+		if checked[alias] || !query.IncludesAccount(alias) {
+			continue
+		}
+		checked[alias] = true
+
+		if p.Allows(ctx, req, alias) {
+			allowed = append(allowed, alias)
+			if !p.AllowsEveryone(ctx, req, alias) {
+				personal = append(personal, alias)
+			}
+			continue
+		}
+
+		deniedAliases = append(deniedAliases, alias)
+		denied = append(denied, search.AccountStatus{
+			Alias:  alias,
+			State:  search.AccountDenied,
+			Reason: fmt.Sprintf("you're not allowed to search %s here", alias),
+		})
+	}
+
+	if len(query.Accounts) > 0 && len(deniedAliases) > 0 {
+		return query, nil, nil, &DeniedError{Aliases: deniedAliases}
+	}
+
+	if len(allowed) == 0 && len(deniedAliases) > 0 {
+		return query, nil, nil, &DeniedError{}
+	}
+
+	if len(deniedAliases) > 0 {
+		query.Accounts = allowed
+	}
+
+	return query, denied, personal, nil
+}
+
+// inUserGroup reports whether a user is in a user group. Members are cached
+// for a few minutes, so that we don't look them up on every search
+func (p *Policy) inUserGroup(ctx context.Context, group, user string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cached, ok := p.groupMembers[group]
+	if !ok || p.now().Sub(cached.fetched) > userGroupCacheTTL {
+		if p.userGroups == nil {
+			log.Printf("can't check whether %s is in user group %s without SLACK_BOT_TOKEN", user, group)
+			return false
+		}
+
+		members, err := p.userGroups.UserGroupMembers(ctx, group)
+		if err != nil {
+			log.Print(err)
+			bugsnag.Notify(err)
+			return false
+		}
+
+		cached = userGroupMembers{users: map[string]bool{}, fetched: p.now()}
+		for _, member := range members {
+			cached.users[member] = true
+		}
+		p.groupMembers[group] = cached
+	}
+
+	return cached.users[user]
+}
+
+func contains(ids []string, id string) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+
+	return false
+}
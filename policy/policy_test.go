@@ -0,0 +1,261 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/geckoboard/slash-infra/search"
+)
+
+type fakeUserGroups struct {
+	members map[string][]string
+	err     error
+	lookups int
+}
+
+func (f *fakeUserGroups) UserGroupMembers(ctx context.Context, userGroupID string) ([]string, error) {
+	f.lookups++
+	return f.members[userGroupID], f.err
+}
+
+func TestPolicyAllows(t *testing.T) {
+	groups := &fakeUserGroups{members: map[string][]string{"S_SRE": []string{"U_ONCALL"}}}
+
+	policy := New(map[string]Rule{
+		"production": Rule{Channels: []string{"C_SRE", "C_INCIDENTS"}, UserGroups: []string{"S_SRE"}},
+		"STAGING":    Rule{Users: []string{"U_ADMIN"}, Teams: []string{"T_ACME"}},
+		"LEGACY":     Rule{Teams: []string{"T_ACME"}},
+	}, groups)
+
+	cases := []struct {
+		req      Request
+		alias    string
+		expected bool
+	}{
+		{Request{TeamID: "T_ACME", ChannelID: "C_RANDOM", UserID: "U_NEW"}, "DEV", true},
+		{Request{TeamID: "T_ACME", ChannelID: "C_SRE", UserID: "U_NEW"}, "PRODUCTION", true},
+		{Request{TeamID: "T_ACME", ChannelID: "C_RANDOM", UserID: "U_NEW"}, "PRODUCTION", false},
+		{Request{TeamID: "T_ACME", ChannelID: "D_DM", UserID: "U_ONCALL"}, "PRODUCTION", true},
+		{Request{TeamID: "T_ACME", ChannelID: "C_RANDOM", UserID: "U_ADMIN"}, "staging", true},
+		{Request{TeamID: "T_OTHER", ChannelID: "C_RANDOM", UserID: "U_ADMIN"}, "STAGING", false},
+		{Request{TeamID: "T_ACME", ChannelID: "C_RANDOM", UserID: "U_NEW"}, "LEGACY", true},
+		{Request{TeamID: "T_OTHER", ChannelID: "C_RANDOM", UserID: "U_NEW"}, "LEGACY", false},
+		{Request{TeamID: "T_ACME", ChannelID: "C_SRE", UserID: "U_NEW", Shared: true}, "PRODUCTION", true},
+		{Request{TeamID: "T_ACME", ChannelID: "D_DM", UserID: "U_ONCALL", Shared: true}, "PRODUCTION", false},
+		{Request{TeamID: "T_ACME", ChannelID: "C_RANDOM", UserID: "U_ADMIN", Shared: true}, "STAGING", false},
+		{Request{TeamID: "T_ACME", ChannelID: "C_RANDOM", UserID: "U_NEW", Shared: true}, "LEGACY", true},
+	}
+
+	for _, c := range cases {
+		if actual := policy.Allows(context.Background(), c.req, c.alias); actual != c.expected {
+			t.Errorf("Allows(%#v, %q) = %t, expected %t", c.req, c.alias, actual, c.expected)
+		}
+	}
+
+	t.Run("It caches who is in a user group", func(t *testing.T) {
+		groups.lookups = 0
+		now := time.Now()
+		policy.now = func() time.Time { return now }
+		policy.groupMembers = map[string]userGroupMembers{}
+
+		req := Request{ChannelID: "C_RANDOM", UserID: "U_ONCALL"}
+		policy.Allows(context.Background(), req, "PRODUCTION")
+		policy.Allows(context.Background(), req, "PRODUCTION")
+
+		if groups.lookups != 1 {
+			t.Errorf("expected the user group to be looked up once, got %d", groups.lookups)
+		}
+
+		now = now.Add(userGroupCacheTTL + time.Second)
+		policy.Allows(context.Background(), req, "PRODUCTION")
+
+		if groups.lookups != 2 {
+			t.Errorf("expected the user group to be looked up again once the cache expired, got %d", groups.lookups)
+		}
+	})
+
+	t.Run("It denies access if the user group can't be looked up", func(t *testing.T) {
+		failing := New(map[string]Rule{"PRODUCTION": Rule{UserGroups: []string{"S_SRE"}}}, &fakeUserGroups{err: errors.New("missing_scope")})
+
+		if failing.Allows(context.Background(), Request{UserID: "U_ONCALL"}, "PRODUCTION") {
+			t.Error("expected access to be denied")
+		}
+
+		withoutToken := New(map[string]Rule{"PRODUCTION": Rule{UserGroups: []string{"S_SRE"}}}, nil)
+
+		if withoutToken.Allows(context.Background(), Request{UserID: "U_ONCALL"}, "PRODUCTION") {
+			t.Error("expected access to be denied")
+		}
+	})
+}
+
+func TestPolicyRestrict(t *testing.T) {
+	policy := New(map[string]Rule{
+		"PRODUCTION": Rule{Channels: []string{"C_SRE"}},
+		"STAGING":    Rule{Channels: []string{"C_SRE"}},
+	}, nil)
+
+	accounts := []search.Account{
+		{Alias: "DEV", Region: "eu-west-2"},
+		{Alias: "STAGING", Region: "eu-west-2"},
+		{Alias: "PRODUCTION", Region: "eu-west-2"},
+	}
+	random := Request{ChannelID: "C_RANDOM", UserID: "U_NEW"}
+
+	t.Run("It leaves the query alone if every account is allowed", func(t *testing.T) {
+		query := search.Query{Text: "i-0123456789abcdef0"}
+
+		restricted, denied, personal, err := policy.Restrict(context.Background(), Request{ChannelID: "C_SRE"}, query, accounts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(restricted, query) || len(denied) != 0 || len(personal) != 0 {
+			t.Errorf("unexpected restriction %#v %#v %#v", restricted, denied, personal)
+		}
+	})
+
+	t.Run("It only searches the allowed accounts", func(t *testing.T) {
+		restricted, denied, _, err := policy.Restrict(context.Background(), random, search.Query{Text: "i-0123456789abcdef0"}, accounts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(restricted.Accounts, []string{"DEV"}) {
+			t.Errorf("unexpected accounts %#v", restricted.Accounts)
+		}
+
+		expected := []search.AccountStatus{
+			{Alias: "STAGING", State: search.AccountDenied, Reason: "you're not allowed to search STAGING here"},
+			{Alias: "PRODUCTION", State: search.AccountDenied, Reason: "you're not allowed to search PRODUCTION here"},
+		}
+		if !reflect.DeepEqual(denied, expected) {
+			t.Errorf("unexpected denied accounts %#v", denied)
+		}
+	})
+
+	t.Run("It refuses to search accounts that were asked for by name", func(t *testing.T) {
+		query := search.Query{Text: "i-0123456789abcdef0", Accounts: []string{"dev", "production"}}
+
+		_, _, _, err := policy.Restrict(context.Background(), random, query, accounts)
+		if err == nil || err.Error() != "you're not allowed to search PRODUCTION here" {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("It refuses to search if no account is allowed", func(t *testing.T) {
+		locked := New(map[string]Rule{"DEV": Rule{Channels: []string{"C_DEV"}}}, nil)
+
+		_, _, _, err := locked.Restrict(context.Background(), random, search.Query{Text: "i-0123456789abcdef0"}, accounts[:1])
+		if err == nil || err.Error() != "you're not allowed to search any accounts here" {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("It reports accounts that are only allowed because of who the user is", func(t *testing.T) {
+		admins := New(map[string]Rule{
+			"PRODUCTION": Rule{Channels: []string{"C_SRE"}, Users: []string{"U_ADMIN"}},
+			"STAGING":    Rule{Channels: []string{"C_RANDOM"}, Users: []string{"U_ADMIN"}},
+		}, nil)
+		admin := Request{ChannelID: "C_RANDOM", UserID: "U_ADMIN"}
+
+		restricted, denied, personal, err := admins.Restrict(context.Background(), admin, search.Query{Text: "i-0123456789abcdef0"}, accounts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(restricted.Accounts) != 0 || len(denied) != 0 {
+			t.Errorf("expected every account to be searched, got %#v %#v", restricted.Accounts, denied)
+		}
+		if !reflect.DeepEqual(personal, []string{"PRODUCTION"}) {
+			t.Errorf("unexpected personal accounts %#v", personal)
+		}
+	})
+
+	t.Run("It leaves those accounts out of shared requests", func(t *testing.T) {
+		admins := New(map[string]Rule{"PRODUCTION": Rule{Channels: []string{"C_SRE"}, Users: []string{"U_ADMIN"}}}, nil)
+		admin := Request{ChannelID: "C_RANDOM", UserID: "U_ADMIN", Shared: true}
+
+		restricted, denied, personal, err := admins.Restrict(context.Background(), admin, search.Query{Text: "i-0123456789abcdef0"}, accounts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(restricted.Accounts, []string{"DEV", "STAGING"}) || len(personal) != 0 {
+			t.Errorf("unexpected restriction %#v %#v", restricted.Accounts, personal)
+		}
+		if len(denied) != 1 || denied[0].Alias != "PRODUCTION" {
+			t.Errorf("unexpected denied accounts %#v", denied)
+		}
+	})
+}
+
+func TestFromEnvironment(t *testing.T) {
+	os.Setenv("ALLOW_CHANNELS_PRODUCTION", "C_SRE, C_INCIDENTS")
+	os.Setenv("ALLOW_USERGROUPS_PRODUCTION", "S_SRE")
+	os.Setenv("ALLOW_TEAMS_STAGING", "T_ACME")
+	defer os.Unsetenv("ALLOW_CHANNELS_PRODUCTION")
+	defer os.Unsetenv("ALLOW_USERGROUPS_PRODUCTION")
+	defer os.Unsetenv("ALLOW_TEAMS_STAGING")
+
+	policy := FromEnvironment(nil)
+
+	expected := map[string]Rule{
+		"PRODUCTION": Rule{Channels: []string{"C_SRE", "C_INCIDENTS"}, UserGroups: []string{"S_SRE"}},
+		"STAGING":    Rule{Teams: []string{"T_ACME"}},
+	}
+	if !reflect.DeepEqual(policy.rules, expected) {
+		t.Errorf("unexpected rules %#v", policy.rules)
+	}
+}
+
+type fakeAccountIDs map[string]string
+
+func (f fakeAccountIDs) AccountIDs(ctx context.Context) map[string]string {
+	return f
+}
+
+func TestPolicyCheckAccounts(t *testing.T) {
+	policy := New(map[string]Rule{
+		"PRODUCTION_EU": Rule{Channels: []string{"C_SRE", "C_INCIDENTS"}},
+		"PRODUCTION_US": Rule{Channels: []string{"C_INCIDENTS", "C_SRE"}},
+	}, nil)
+
+	t.Run("It allows aliases for the same account with the same rules", func(t *testing.T) {
+		err := policy.CheckAccounts(context.Background(), fakeAccountIDs{
+			"PRODUCTION_EU": "123456789012",
+			"PRODUCTION_US": "123456789012",
+			"DEV":           "210987654321",
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("It rejects an alias that would get around another alias's rules", func(t *testing.T) {
+		err := policy.CheckAccounts(context.Background(), fakeAccountIDs{
+			"PRODUCTION_EU": "123456789012",
+			"PRODUCTION_US": "123456789012",
+			"BACKDOOR":      "123456789012",
+		})
+		if err == nil || err.Error() != "BACKDOOR and PRODUCTION_EU are both account 123456789012, so must have the same ALLOW_ rules" {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("It rejects aliases whose account ID isn't known", func(t *testing.T) {
+		if err := policy.CheckAccounts(context.Background(), fakeAccountIDs{"PRODUCTION_EU": ""}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("It doesn't look up account IDs without any rules", func(t *testing.T) {
+		if err := New(nil, nil).CheckAccounts(context.Background(), fakeAccountIDs{"DEV": ""}); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package search
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ConsoleResource is the resource an AWS console link points at
+type ConsoleResource struct {
+	Region string
+
+	// The kind of result the resource would be found as, e.g. ec2.instance
+	ResourceType string
+
+	// The instance ID or IP address
+	ID string
+}
+
+var (
+	// e.g. console.aws.amazon.com or eu-west-2.console.aws.amazon.com
+	consoleHostPattern = regexp.MustCompile(`^(?:([a-z]{2}(?:-gov)?-[a-z]+-\d)\.)?console\.aws\.amazon\.com$`)
+
+	// e.g. /timeline/AWS::EC2::Instance/i-0123456789abcdef0/configuration
+	configTimelineFragmentPattern = regexp.MustCompile(`^/timeline/AWS::EC2::Instance/(i-[0-9a-f]+)(?:/|$)`)
+)
+
+// ec2ConsoleViews maps the views in the EC2 console that show a single
+// resource to the kind of result they show
+var ec2ConsoleViews = map[string]string{
+	"Instances":       "ec2.instance",
+	"InstanceDetails": "ec2.instance",
+	"Addresses":       "ec2.elastic_ip",
+}
+
+// ParseConsoleURL works out which resource a link to the AWS console is for,
+// e.g. https://eu-west-2.console.aws.amazon.com/ec2/v2/home?region=eu-west-2#InstanceDetails:instanceId=i-0123456789abcdef0
+// Returns false if the link isn't to an instance or IP address we can search
+// for
+func ParseConsoleURL(rawURL string) (ConsoleResource, bool) {
+	link, err := url.Parse(rawURL)
+	if err != nil {
+		return ConsoleResource{}, false
+	}
+
+	host := consoleHostPattern.FindStringSubmatch(strings.ToLower(link.Hostname()))
+	if host == nil {
+		return ConsoleResource{}, false
+	}
+
+	region := link.Query().Get("region")
+	if region == "" {
+		region = host[1]
+	}
+
+	resource := ConsoleResource{Region: region}
+
+	switch {
+	case strings.HasPrefix(link.Path, "/ec2/"):
+		view, params := parseEc2ConsoleFragment(link.Fragment)
+
+		resourceType, ok := ec2ConsoleViews[view]
+		if !ok {
+			return ConsoleResource{}, false
+		}
+
+		resource.ResourceType = resourceType
+		resource.ID = params["instanceId"]
+		if resource.ID == "" {
+			resource.ID = params["search"]
+		}
+
+	case strings.HasPrefix(link.Path, "/config/"):
+		match := configTimelineFragmentPattern.FindStringSubmatch(link.Fragment)
+		if match == nil {
+			return ConsoleResource{}, false
+		}
+
+		resource.ResourceType = "ec2.instance"
+		resource.ID = match[1]
+
+	default:
+		return ConsoleResource{}, false
+	}
+
+	// The search parameter can be anything someone typed in to the console,
+	// so we only accept values that definitely identify a single resource
+	switch ClassifyQuery(resource.ID).Type {
+	case IdentifierEc2InstanceID, IdentifierPrivateIP, IdentifierPublicIP:
+		return resource, true
+	}
+
+	return ConsoleResource{}, false
+}
+
+// parseEc2ConsoleFragment splits the fragment of an EC2 console link, e.g.
+// Instances:search=i-0123456789abcdef0;sort=desc:launchTime, into the view
+// and its parameters
+func parseEc2ConsoleFragment(fragment string) (string, map[string]string) {
+	params := map[string]string{}
+
+	parts := strings.SplitN(fragment, ":", 2)
+	if len(parts) == 2 {
+		for _, param := range strings.Split(parts[1], ";") {
+			if pair := strings.SplitN(param, "=", 2); len(pair) == 2 {
+				params[pair[0]] = pair[1]
+			}
+		}
+	}
+
+	return parts[0], params
+}
@@ -0,0 +1,48 @@
+package search
+
+import "testing"
+
+func TestParseConsoleURL(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected ConsoleResource
+		ok       bool
+	}{
+		{
+			"https://eu-west-2.console.aws.amazon.com/ec2/v2/home?region=eu-west-2#InstanceDetails:instanceId=i-0123456789abcdef0",
+			ConsoleResource{Region: "eu-west-2", ResourceType: "ec2.instance", ID: "i-0123456789abcdef0"},
+			true,
+		},
+		{
+			"https://console.aws.amazon.com/ec2/v2/home?region=us-east-1#Instances:search=i-0123456789abcdef0;sort=desc:launchTime",
+			ConsoleResource{Region: "us-east-1", ResourceType: "ec2.instance", ID: "i-0123456789abcdef0"},
+			true,
+		},
+		{
+			"https://eu-west-1.console.aws.amazon.com/ec2/home#Instances:search=10.1.2.3",
+			ConsoleResource{Region: "eu-west-1", ResourceType: "ec2.instance", ID: "10.1.2.3"},
+			true,
+		},
+		{
+			"https://console.aws.amazon.com/ec2/v2/home?region=eu-west-2#Addresses:search=3.8.1.2",
+			ConsoleResource{Region: "eu-west-2", ResourceType: "ec2.elastic_ip", ID: "3.8.1.2"},
+			true,
+		},
+		{
+			"https://console.aws.amazon.com/config/home?region=eu-west-2#/timeline/AWS::EC2::Instance/i-0123456789abcdef0/configuration",
+			ConsoleResource{Region: "eu-west-2", ResourceType: "ec2.instance", ID: "i-0123456789abcdef0"},
+			true,
+		},
+		{"https://console.aws.amazon.com/ec2/v2/home?region=eu-west-2#Instances:search=api-worker", ConsoleResource{}, false},
+		{"https://console.aws.amazon.com/ec2/v2/home?region=eu-west-2#SecurityGroups:search=sg-12345678", ConsoleResource{}, false},
+		{"https://console.aws.amazon.com/s3/buckets/example", ConsoleResource{}, false},
+		{"https://example.com/ec2/v2/home#InstanceDetails:instanceId=i-0123456789abcdef0", ConsoleResource{}, false},
+	}
+
+	for _, c := range cases {
+		actual, ok := ParseConsoleURL(c.url)
+		if ok != c.ok || actual != c.expected {
+			t.Errorf("%s: expected %#v (%v), got %#v (%v)", c.url, c.expected, c.ok, actual, ok)
+		}
+	}
+}
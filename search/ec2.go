@@ -5,23 +5,84 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
 	bugsnag "github.com/bugsnag/bugsnag-go"
 )
 
-// This is 17 characters plus the "i-" prefix
-const ExactEc2InstanceIDLength = 19
 const EnvVarPrefixForAwsRoles = "AWS_ROLE_"
 
 type ec2SDK interface {
-	DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error)
+	DescribeInstancesPagesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error
+	DescribeAddressesWithContext(ctx aws.Context, input *ec2.DescribeAddressesInput, opts ...request.Option) (*ec2.DescribeAddressesOutput, error)
+	DescribeSecurityGroupsWithContext(ctx aws.Context, input *ec2.DescribeSecurityGroupsInput, opts ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error)
+	GetConsoleOutputWithContext(ctx aws.Context, input *ec2.GetConsoleOutputInput, opts ...request.Option) (*ec2.GetConsoleOutputOutput, error)
+}
+
+type stsSDK interface {
+	GetCallerIdentityWithContext(ctx aws.Context, input *sts.GetCallerIdentityInput, opts ...request.Option) (*sts.GetCallerIdentityOutput, error)
+}
+
+// ec2Client is an EC2 API client for a single AWS account and region, along
+// with the alias the account was configured under
+type ec2Client struct {
+	ec2SDK
+
+	alias    string
+	region   string
+	identity *accountIdentity
+
+	// The role people should switch to when following links to the
+	// console
+	consoleRoleName string
+}
+
+// accountID returns the ID of the AWS account the client searches, or an
+// empty string if it isn't known
+func (c ec2Client) accountID(ctx context.Context) string {
+	if c.identity == nil {
+		return ""
+	}
+
+	return c.identity.ID(ctx)
+}
+
+// accountIdentity looks up the ID of the account a role belongs to. Account
+// IDs never change, so it only asks STS until it gets an answer
+type accountIdentity struct {
+	client stsSDK
+
+	mu sync.Mutex
+	id string
+}
+
+func (a *accountIdentity) ID(ctx context.Context) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.id != "" {
+		return a.id
+	}
+
+	output, err := a.client.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Printf("could not get caller identity: %s", err)
+		return ""
+	}
+
+	a.id = aws.StringValue(output.Account)
+	return a.id
 }
 
 // buildEc2ClientsFromEnvironment uses environment variables to build instances
@@ -36,6 +97,10 @@ type ec2SDK interface {
 // `AWS_REGION_{account alias}` - If the account's resources are in a region
 // other than us-east-1, specify it here.
 //
+// `AWS_CONSOLE_ROLE_{account alias}` - The name of the role people should
+// switch to when following links to the AWS console. Defaults to the name of
+// the role slash-infra assumes.
+//
 // If an account uses several regions, then you can specify role several times
 // under different aliases. e.g.
 //
@@ -43,8 +108,8 @@ type ec2SDK interface {
 // AWS_ROLE_DEV_US_EAST=...
 // AWS_ROLE_DEV_EU=...
 // ```
-func buildEc2ClientsFromEnvironment() []ec2SDK {
-	clients := []ec2SDK{}
+func buildEc2ClientsFromEnvironment() []ec2Client {
+	clients := []ec2Client{}
 	environ := os.Environ()
 
 	for _, pair := range environ {
@@ -73,22 +138,93 @@ func buildEc2ClientsFromEnvironment() []ec2SDK {
 		creds := stscreds.NewCredentials(sess, roleArn)
 		svc := ec2.New(sess, &aws.Config{Credentials: creds})
 
-		clients = append(clients, svc)
+		consoleRoleName := os.Getenv(fmt.Sprintf("AWS_CONSOLE_ROLE_%s", awsAccountAlias))
+		if consoleRoleName == "" {
+			consoleRoleName = roleNameFromArn(roleArn)
+		}
+
+		clients = append(clients, ec2Client{
+			ec2SDK:          svc,
+			alias:           awsAccountAlias,
+			region:          region,
+			identity:        &accountIdentity{client: sts.New(sess, &aws.Config{Credentials: creds})},
+			consoleRoleName: consoleRoleName,
+		})
 	}
 
 	return clients
 }
 
+// NewEc2 builds a resolver that searches every account configured in the
+// environment (see buildEc2ClientsFromEnvironment).
+//
+// The timeouts can be changed with `SEARCH_TIMEOUT` and
+// `SEARCH_ACCOUNT_TIMEOUT`, which accept durations like `10s`. The number of
+// results returned can be changed with `SEARCH_MAX_RESULTS`
 func NewEc2() *EC2Resolver {
-	return &EC2Resolver{clients: buildEc2ClientsFromEnvironment()}
+	return &EC2Resolver{
+		clients:              buildEc2ClientsFromEnvironment(),
+		searchTimeout:        durationFromEnvironment("SEARCH_TIMEOUT", DefaultSearchTimeout),
+		accountSearchTimeout: durationFromEnvironment("SEARCH_ACCOUNT_TIMEOUT", DefaultAccountSearchTimeout),
+		maxResults:           intFromEnvironment("SEARCH_MAX_RESULTS", DefaultMaxResults),
+	}
+}
+
+func intFromEnvironment(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		log.Printf("ignoring invalid number %q in %s", value, key)
+		return defaultValue
+	}
+
+	return number
+}
+
+func durationFromEnvironment(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("ignoring invalid duration %q in %s: %s", value, key, err)
+		return defaultValue
+	}
+
+	return duration
 }
 
 type Result struct {
-	Kind     string
+	Kind string
+
+	// Where the result was found
+	AccountAlias string
+	AccountID    string
+	Region       string
+
 	Metadata map[string][]string
 	Links    map[string]string
 }
 
+// Location describes where the result was found, e.g. "PRODUCTION / eu-west-2"
+func (r Result) Location() string {
+	parts := []string{}
+
+	for _, part := range []string{r.AccountAlias, r.Region} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, " / ")
+}
+
 func (r Result) GetMetadata(key string) string {
 	set, ok := r.Metadata[key]
 	if !ok {
@@ -111,49 +247,394 @@ type ResultSet struct {
 	Kind       string
 	SearchLink string
 	Results    []Result
+
+	// How many results matched the query. This will be more than
+	// len(Results) if there were too many results to return them all
+	Total int
+
+	// Set if an account ran out of time before every page of results was
+	// fetched, in which case Total is only a lower bound
+	Incomplete bool
+}
+
+// Truncated reports whether some of the results were left out
+func (r ResultSet) Truncated() bool {
+	return r.Total > len(r.Results) || r.Incomplete
 }
 
+const (
+	// How long we'll wait for all accounts to be searched
+	DefaultSearchTimeout = 8 * time.Second
+
+	// How long we'll wait for any one account to be searched
+	DefaultAccountSearchTimeout = 5 * time.Second
+
+	// The most results of each kind that we'll return from one search
+	DefaultMaxResults = 50
+)
+
 type EC2Resolver struct {
-	clients []ec2SDK
+	clients []ec2Client
+
+	searchTimeout        time.Duration
+	accountSearchTimeout time.Duration
+	maxResults           int
+}
+
+// CanHandle reports whether the query looks like an identifier the EC2 API
+// can search for
+func (e *EC2Resolver) CanHandle(query string) bool {
+	_, ok := ec2Finders[ClassifyQuery(query).Type]
+	return ok
+}
+
+// resultLimit is the most results of each kind to return. Queries can ask
+// for fewer results, but not more than we're configured to allow
+func (e *EC2Resolver) resultLimit(requested int) int {
+	limit := e.maxResults
+	if limit <= 0 {
+		limit = DefaultMaxResults
+	}
+
+	if requested > 0 && requested < limit {
+		return requested
+	}
+
+	return limit
+}
+
+// Accounts lists the account and region each client searches
+func (e *EC2Resolver) Accounts() []Account {
+	accounts := []Account{}
+
+	for _, client := range e.clients {
+		accounts = append(accounts, Account{Alias: client.alias, Region: client.region})
+	}
+
+	return accounts
+}
+
+// AccountIDs returns the ID of the AWS account behind each alias. The ID is
+// empty if it couldn't be found
+func (e *EC2Resolver) AccountIDs(ctx context.Context) map[string]string {
+	ids := map[string]string{}
+
+	for _, client := range e.clients {
+		ids[client.alias] = client.accountID(ctx)
+	}
+
+	return ids
 }
 
-func (e *EC2Resolver) Search(ctx context.Context, query string) []ResultSet {
-	results := []ResultSet{}
+// Describe lists the kinds of identifier that can be searched for in EC2
+func (e *EC2Resolver) Describe() Description {
+	return Description{
+		Name:     "EC2 instances and Elastic IPs",
+		Examples: ec2Examples,
+	}
+}
 
-	query = strings.TrimSpace(query)
+// clientsFor returns the clients for the accounts and regions the query
+// should search
+func (e *EC2Resolver) clientsFor(query Query) []ec2Client {
+	clients := []ec2Client{}
 
 	for _, client := range e.clients {
-		result, err := findEC2InstancesByID(ctx, client, query)
+		if query.IncludesAccount(client.alias) && query.IncludesRegion(client.region) {
+			clients = append(clients, client)
+		}
+	}
+
+	return clients
+}
+
+// accountSearch is the outcome of searching a single account
+type accountSearch struct {
+	client     int
+	resultSets []ResultSet
+	status     AccountStatus
+}
+
+// Search queries every account in parallel. Each account has its own
+// timeout, and if the overall timeout is reached then Search returns
+// whatever has been found so far
+func (e *EC2Resolver) Search(ctx context.Context, query Query) Results {
+	results := Results{Sets: []ResultSet{}}
+
+	identifier := ClassifyQuery(query.Text)
+
+	finders, ok := ec2Finders[identifier.Type]
+	if !ok {
+		return results
+	}
+
+	clients := e.clientsFor(query)
+	limit := e.resultLimit(query.Limit)
+
+	ctx, cancel := context.WithTimeout(ctx, e.searchTimeout)
+	defer cancel()
+
+	// Buffered so that accounts which finish after we've given up on them
+	// don't block forever
+	completed := make(chan accountSearch, len(clients))
+
+	for i, client := range clients {
+		go func(i int, client ec2Client) {
+			completed <- e.searchAccount(ctx, i, client, finders, identifier, limit)
+		}(i, client)
+	}
+
+	searches := make([]*accountSearch, len(clients))
+	reportProgress(ctx, Progress{Searched: 0, Total: len(clients)})
+
+collect:
+	for remaining := len(clients); remaining > 0; remaining-- {
+		select {
+		case search := <-completed:
+			searches[search.client] = &search
+			reportProgress(ctx, Progress{Searched: len(clients) - remaining + 1, Total: len(clients)})
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	// Results are ordered by client, rather than by how quickly each
+	// account responded, so that responses are consistent
+	for i, search := range searches {
+		if search == nil {
+			results.Accounts = append(results.Accounts, AccountStatus{Alias: clients[i].alias, State: AccountTimedOut})
+			continue
+		}
+
+		results.Sets = append(results.Sets, search.resultSets...)
+		results.Accounts = append(results.Accounts, search.status)
+	}
+
+	results.Sets = mergeResultSets(results.Sets, limit)
+
+	return results
+}
+
+// mergeResultSets combines result sets of the same kind from different
+// accounts, so that the limit applies to the search as a whole rather than
+// to each account
+func mergeResultSets(sets []ResultSet, limit int) []ResultSet {
+	merged := []ResultSet{}
+	byKind := map[string]int{}
+
+	for _, set := range sets {
+		i, ok := byKind[set.Kind]
+		if !ok {
+			i = len(merged)
+			byKind[set.Kind] = i
+			merged = append(merged, ResultSet{Kind: set.Kind, SearchLink: set.SearchLink, Results: []Result{}})
+		}
+
+		merged[i].Results = append(merged[i].Results, set.Results...)
+		merged[i].Total += set.Total
+		merged[i].Incomplete = merged[i].Incomplete || set.Incomplete
+	}
+
+	for i := range merged {
+		if len(merged[i].Results) > limit {
+			merged[i].Results = merged[i].Results[:limit]
+		}
+	}
+
+	return merged
+}
+
+func (e *EC2Resolver) searchAccount(ctx context.Context, i int, client ec2Client, finders []ec2Finder, identifier Identifier, limit int) accountSearch {
+	outcome := accountSearch{
+		client: i,
+		status: AccountStatus{Alias: client.alias, State: AccountOK},
+	}
+
+	// There's no point asking an account to look for something that we
+	// know is in a different region
+	if identifier.Region != "" && client.region != "" && identifier.Region != client.region {
+		outcome.status.State = AccountSkipped
+		outcome.status.Reason = fmt.Sprintf("not in %s", identifier.Region)
+		return outcome
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.accountSearchTimeout)
+	defer cancel()
+
+	// The account ID is only needed for the switch role link, so it's
+	// looked up alongside the search rather than holding it up. The lookup
+	// isn't cancelled with the search, so a slow answer is still remembered
+	// for next time
+	accountID := make(chan string, 1)
+	go func() {
+		lookupCtx, cancel := context.WithTimeout(context.Background(), e.accountSearchTimeout)
+		defer cancel()
+		accountID <- client.accountID(lookupCtx)
+	}()
+
+	for _, find := range finders {
+		result, err := find(ctx, client, identifier.Value, limit)
 
 		if err != nil {
+			// Whatever was found before running out of time is still
+			// worth showing
+			if ctx.Err() == context.DeadlineExceeded {
+				outcome.status.State = AccountTimedOut
+				if result != nil {
+					outcome.resultSets = append(outcome.resultSets, *result)
+				}
+				break
+			}
+
 			log.Print(err)
+
+			// Carry on with the other finders, as they may use API
+			// calls that the account does allow
+			if outcome.status.State != AccountError {
+				outcome.status.State = AccountError
+				outcome.status.Reason = errorReason(err)
+				outcome.status.Err = err
+			}
 		}
 
 		if result != nil {
-			results = append(results, *result)
+			outcome.resultSets = append(outcome.resultSets, *result)
 		}
+	}
 
+	// Results are shown without the link rather than waiting past the
+	// account's timeout
+	id := ""
+	select {
+	case id = <-accountID:
+	case <-ctx.Done():
 	}
 
-	return results
+	for _, set := range outcome.resultSets {
+		for i := range set.Results {
+			client.annotate(&set.Results[i], id)
+		}
+	}
+
+	return outcome
 }
 
-func findEC2InstancesByID(ctx context.Context, client ec2SDK, search string) (*ResultSet, error) {
-	// EC2 instance IDs have a very specific format
-	if !strings.HasPrefix(search, "i-") {
-		return nil, nil
+// annotate says which account and region a result came from, and links to
+// switch to that account in the console
+func (c ec2Client) annotate(result *Result, accountID string) {
+	result.AccountAlias = c.alias
+	result.AccountID = accountID
+	result.Region = c.region
+
+	if switchRole := switchRoleLink(accountID, c.consoleRoleName, c.alias); switchRole != "" {
+		if result.Links == nil {
+			result.Links = map[string]string{}
+		}
+		result.Links["switch_role"] = switchRole
 	}
+}
 
-	// The EC2 API does not allow you to do substring searches
-	if len(search) != ExactEc2InstanceIDLength {
-		return nil, nil
+// errorReason summarises an error, using the AWS error code (e.g.
+// AccessDenied) if there is one
+func errorReason(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
 	}
 
-	output, err := client.DescribeInstancesWithContext(
+	return err.Error()
+}
+
+// ec2Finder searches a single account, returning at most limit results
+type ec2Finder func(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error)
+
+// ec2Examples explains each kind of identifier in ec2Finders, in the order
+// they're shown in help
+var ec2Examples = []Example{
+	{"an instance ID", "i-0123456789abcdef0"},
+	{"a private IP address", "10.1.2.3"},
+	{"a public or Elastic IP address", "3.8.1.2"},
+	{"an instance's Name tag, using `*` as a wildcard", "name:api-worker-*"},
+	{"a private EC2 DNS name", "ip-10-1-2-3.eu-west-2.compute.internal"},
+	{"a public EC2 DNS name", "ec2-3-8-1-2.eu-west-2.compute.amazonaws.com"},
+	{"a network interface ID", "eni-0123456789abcdef0"},
+	{"a security group ID", "sg-0123456789abcdef0"},
+	{"a subnet ID", "subnet-0123456789abcdef0"},
+}
+
+// ec2Finders lists the finders that are run against every client for each
+// type of identifier. Each finder is passed the identifier's value, rather
+// than the raw query
+var ec2Finders = map[IdentifierType][]ec2Finder{
+	IdentifierEc2InstanceID:     []ec2Finder{findEC2InstancesByID},
+	IdentifierPrivateIP:         []ec2Finder{findEC2InstancesByPrivateIP},
+	IdentifierPublicIP:          []ec2Finder{findEC2InstancesByPublicIP, findUnattachedElasticIPs},
+	IdentifierNameTag:           []ec2Finder{findEC2InstancesByName},
+	IdentifierEc2PrivateDNSName: []ec2Finder{findEC2InstancesByPrivateDNSName},
+	IdentifierEc2PublicDNSName:  []ec2Finder{findEC2InstancesByPublicDNSName},
+
+	IdentifierEc2NetworkInterfaceID: []ec2Finder{findEC2InstancesByNetworkInterfaceID},
+	IdentifierEc2SecurityGroupID:    []ec2Finder{findEC2InstancesBySecurityGroupID},
+	IdentifierEc2SubnetID:           []ec2Finder{findEC2InstancesBySubnetID},
+}
+
+func findEC2InstancesByID(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	return describeInstancesMatchingAny(ctx, client, limit, search, "instance-id")
+}
+
+func findEC2InstancesByPrivateIP(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	// Filters in a single DescribeInstances call are ANDed together, so we
+	// need one call for the primary IP and another to catch any secondary
+	// IPs or additional network interfaces
+	return describeInstancesMatchingAny(ctx, client, limit, search, "private-ip-address", "network-interface.addresses.private-ip-address")
+}
+
+func findEC2InstancesByPublicIP(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	// `ip-address` only matches the primary network interface, so we also
+	// check the public IPs associated with every other interface
+	return describeInstancesMatchingAny(ctx, client, limit, search, "ip-address", "network-interface.association.public-ip")
+}
+
+// findEC2InstancesByName finds instances using their Name tag, e.g.
+// `name:api-worker-*`. The EC2 API supports `*` and `?` wildcards in filter
+// values, so we pass the pattern straight through
+func findEC2InstancesByName(ctx context.Context, client ec2Client, pattern string, limit int) (*ResultSet, error) {
+	return describeInstancesMatchingAny(ctx, client, limit, pattern, "tag:Name")
+}
+
+func findEC2InstancesByPrivateDNSName(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	return describeInstancesMatchingAny(ctx, client, limit, search, "private-dns-name")
+}
+
+func findEC2InstancesByPublicDNSName(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	return describeInstancesMatchingAny(ctx, client, limit, search, "dns-name")
+}
+
+// findEC2InstancesByNetworkInterfaceID finds the instance a network
+// interface is attached to
+func findEC2InstancesByNetworkInterfaceID(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	return describeInstancesMatchingAny(ctx, client, limit, search, "network-interface.network-interface-id")
+}
+
+// findEC2InstancesBySecurityGroupID finds every instance in a security
+// group. `instance.group-id` only matches the primary network interface,
+// so we also check the groups of every other interface
+func findEC2InstancesBySecurityGroupID(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	return describeInstancesMatchingAny(ctx, client, limit, search, "instance.group-id", "network-interface.group-id")
+}
+
+func findEC2InstancesBySubnetID(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	return describeInstancesMatchingAny(ctx, client, limit, search, "subnet-id")
+}
+
+// findUnattachedElasticIPs finds Elastic IPs that aren't associated with an
+// instance. Elastic IPs attached to an instance are found by
+// findEC2InstancesByPublicIP, so we don't report those twice
+func findUnattachedElasticIPs(ctx context.Context, client ec2Client, search string, limit int) (*ResultSet, error) {
+	output, err := client.DescribeAddressesWithContext(
 		ctx,
-		&ec2.DescribeInstancesInput{
+		&ec2.DescribeAddressesInput{
 			Filters: []*ec2.Filter{
-				&ec2.Filter{Name: aws.String("instance-id"), Values: []*string{aws.String(search)}},
+				&ec2.Filter{Name: aws.String("public-ip"), Values: []*string{aws.String(search)}},
 			},
 		},
 	)
@@ -165,62 +646,175 @@ func findEC2InstancesByID(ctx context.Context, client ec2SDK, search string) (*R
 
 	results := []Result{}
 
-	for _, reservation := range output.Reservations {
-		for _, instance := range reservation.Instances {
-			publicIpAddresses := []string{}
-			privateIpAddresses := []string{}
-
-			// Stopped instances do not appear to have network interfaces
-			if instance.NetworkInterfaces != nil {
-				for _, networkInterface := range instance.NetworkInterfaces {
-					if networkInterface == nil {
-						continue
-					}
-
-					if networkInterface.Association != nil {
-						publicIpAddresses = append(publicIpAddresses, *networkInterface.Association.PublicIp)
-					}
-
-					if networkInterface.PrivateIpAddresses != nil {
-						for _, privateIp := range networkInterface.PrivateIpAddresses {
-							privateIpAddresses = append(privateIpAddresses, *privateIp.PrivateIpAddress)
-						}
-					}
+	for _, address := range output.Addresses {
+		if address.InstanceId != nil && *address.InstanceId != "" {
+			continue
+		}
+
+		results = append(results, elasticIPToResult(client.region, address))
+	}
+
+	total := len(results)
+	if total > limit {
+		results = results[:limit]
+	}
+
+	return &ResultSet{Kind: "ec2.elastic_ip", Results: results, Total: total}, nil
+}
+
+func elasticIPToResult(region string, address *ec2.Address) Result {
+	result := Result{
+		Kind: "ec2.elastic_ip",
+		Metadata: map[string][]string{
+			"public_ip":     []string{aws.StringValue(address.PublicIp)},
+			"allocation_id": []string{aws.StringValue(address.AllocationId)},
+			"domain":        []string{aws.StringValue(address.Domain)},
+		},
+		Links: map[string]string{
+			"ec2_console": elasticIPConsoleLink(region, aws.StringValue(address.PublicIp)),
+		},
+	}
+
+	// Elastic IPs can still be attached to things that aren't instances,
+	// e.g. NAT gateways or load balancers
+	if address.NetworkInterfaceId != nil {
+		result.Metadata["network_interface_id"] = []string{*address.NetworkInterfaceId}
+	}
+	if address.PrivateIpAddress != nil {
+		result.Metadata["private_ips"] = []string{*address.PrivateIpAddress}
+	}
+
+	for _, tag := range address.Tags {
+		result.Metadata[fmt.Sprintf("tag:%s", *tag.Key)] = []string{*tag.Value}
+	}
+
+	return result
+}
+
+// describeInstancesMatchingAny runs a separate DescribeInstances call for
+// each filter, and merges the instances found by each one. If the search
+// runs out of time part way through paging, the instances found so far are
+// returned along with the error
+func describeInstancesMatchingAny(ctx context.Context, client ec2Client, limit int, filterValue string, filterNames ...string) (*ResultSet, error) {
+	collector := newInstanceCollector(client.region, limit)
+
+	for _, filterName := range filterNames {
+		err := describeInstances(ctx, client, collector, filterName, filterValue)
+		if err != nil && ctx.Err() == context.DeadlineExceeded && collector.total > 0 {
+			return &ResultSet{Kind: "ec2.instance", Results: collector.results, Total: collector.total, Incomplete: true}, err
+		}
+		if err != nil {
+			bugsnag.Notify(err)
+			return nil, err
+		}
+	}
+
+	return &ResultSet{Kind: "ec2.instance", Results: collector.results, Total: collector.total}, nil
+}
+
+// describeInstances pages through all instances matching a single EC2 filter,
+// adding them to the collector
+func describeInstances(ctx context.Context, client ec2Client, collector *instanceCollector, filterName, filterValue string) error {
+	return client.DescribeInstancesPagesWithContext(
+		ctx,
+		&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				&ec2.Filter{Name: aws.String(filterName), Values: []*string{aws.String(filterValue)}},
+			},
+		},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					collector.add(instance)
 				}
 			}
 
-			result := Result{
-				Kind: "ec2.instance",
-				Metadata: map[string][]string{
-					"instance_id":    []string{*instance.InstanceId},
-					"ami_id":         []string{*instance.ImageId},
-					"instance_type":  []string{*instance.InstanceType},
-					"instance_state": []string{*instance.State.Name},
-					"az":             []string{*instance.Placement.AvailabilityZone},
-					"public_ips":     publicIpAddresses,
-					"private_ips":    privateIpAddresses,
-				},
-				Links: map[string]string{
-					"ec2_console":     ec2ConsoleLink("us-east-1", *instance.InstanceId),
-					"config_timeline": ec2ConfigTimelineLink("us-east-1", *instance.InstanceId),
-				},
+			return true
+		},
+	)
+}
+
+// instanceCollector gathers instances from one or more DescribeInstances
+// calls, ignoring duplicates. It counts every instance it sees, but only
+// keeps the first few so that huge searches don't use lots of memory
+type instanceCollector struct {
+	region string
+	limit  int
+
+	seen    map[string]bool
+	results []Result
+	total   int
+}
+
+func newInstanceCollector(region string, limit int) *instanceCollector {
+	return &instanceCollector{
+		region:  region,
+		limit:   limit,
+		seen:    map[string]bool{},
+		results: []Result{},
+	}
+}
+
+func (c *instanceCollector) add(instance *ec2.Instance) {
+	id := aws.StringValue(instance.InstanceId)
+	if c.seen[id] {
+		return
+	}
+	c.seen[id] = true
+	c.total++
+
+	if len(c.results) < c.limit {
+		c.results = append(c.results, ec2InstanceToResult(c.region, instance))
+	}
+}
+
+func ec2InstanceToResult(region string, instance *ec2.Instance) Result {
+	publicIpAddresses := []string{}
+	privateIpAddresses := []string{}
+
+	// Stopped instances do not appear to have network interfaces
+	if instance.NetworkInterfaces != nil {
+		for _, networkInterface := range instance.NetworkInterfaces {
+			if networkInterface == nil {
+				continue
 			}
 
-			for _, tag := range instance.Tags {
-				result.Metadata[fmt.Sprintf("tag:%s", *tag.Key)] = []string{*tag.Value}
+			if networkInterface.Association != nil {
+				publicIpAddresses = append(publicIpAddresses, *networkInterface.Association.PublicIp)
 			}
 
-			results = append(results, result)
+			if networkInterface.PrivateIpAddresses != nil {
+				for _, privateIp := range networkInterface.PrivateIpAddresses {
+					privateIpAddresses = append(privateIpAddresses, *privateIp.PrivateIpAddress)
+				}
+			}
 		}
 	}
 
-	return &ResultSet{Kind: "ec2.instance", Results: results}, err
-}
+	result := Result{
+		Kind: "ec2.instance",
+		Metadata: map[string][]string{
+			"instance_id":    []string{*instance.InstanceId},
+			"ami_id":         []string{*instance.ImageId},
+			"instance_type":  []string{*instance.InstanceType},
+			"instance_state": []string{*instance.State.Name},
+			"az":             []string{*instance.Placement.AvailabilityZone},
+			"public_ips":     publicIpAddresses,
+			"private_ips":    privateIpAddresses,
+		},
+		Links: map[string]string{
+			"ec2_console":     ec2ConsoleLink(region, *instance.InstanceId),
+			"config_timeline": ec2ConfigTimelineLink(region, *instance.InstanceId),
+		},
+	}
 
-func ec2ConsoleLink(region, search string) string {
-	return fmt.Sprintf("https://console.aws.amazon.com/ec2/v2/home?region=%s#Instances:search=%s;sort=desc:launchTime", region, search)
-}
+	for _, group := range instance.SecurityGroups {
+		result.Metadata["security_group_ids"] = append(result.Metadata["security_group_ids"], aws.StringValue(group.GroupId))
+	}
+
+	for _, tag := range instance.Tags {
+		result.Metadata[fmt.Sprintf("tag:%s", *tag.Key)] = []string{*tag.Value}
+	}
 
-func ec2ConfigTimelineLink(region, instanceId string) string {
-	return fmt.Sprintf("https://console.aws.amazon.com/config/home?region=%s#/timeline/AWS::EC2::Instance/%s/configuration", region, instanceId)
+	return result
 }
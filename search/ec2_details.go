@@ -0,0 +1,223 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// InstanceRef identifies an instance in a particular account and region, so
+// that follow up lookups (e.g. from buttons in slack) go to the right client
+type InstanceRef struct {
+	AccountAlias string
+	Region       string
+	InstanceID   string
+}
+
+// instanceRefSeparator can't appear in account aliases (which come from
+// environment variable names), regions or instance IDs
+const instanceRefSeparator = "|"
+
+// RefForResult builds a reference to the instance a result describes
+func RefForResult(result Result) InstanceRef {
+	return InstanceRef{
+		AccountAlias: result.AccountAlias,
+		Region:       result.Region,
+		InstanceID:   result.GetMetadata("instance_id"),
+	}
+}
+
+// String encodes the reference, e.g. "PRODUCTION|eu-west-2|i-0123456789abcdef0"
+func (r InstanceRef) String() string {
+	return strings.Join([]string{r.AccountAlias, r.Region, r.InstanceID}, instanceRefSeparator)
+}
+
+func ParseInstanceRef(encoded string) (InstanceRef, error) {
+	parts := strings.Split(encoded, instanceRefSeparator)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return InstanceRef{}, fmt.Errorf("invalid instance reference %q", encoded)
+	}
+
+	return InstanceRef{AccountAlias: parts[0], Region: parts[1], InstanceID: parts[2]}, nil
+}
+
+var ErrUnknownAccount = errors.New("no client is configured for that account and region")
+
+func (e *EC2Resolver) clientFor(ref InstanceRef) (ec2Client, error) {
+	for _, client := range e.clients {
+		if client.alias == ref.AccountAlias && client.region == ref.Region {
+			return client, nil
+		}
+	}
+
+	return ec2Client{}, ErrUnknownAccount
+}
+
+// Instance looks up a single instance
+func (e *EC2Resolver) Instance(ctx context.Context, ref InstanceRef) (Result, error) {
+	client, err := e.clientFor(ref)
+	if err != nil {
+		return Result{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.accountSearchTimeout)
+	defer cancel()
+
+	found, err := describeInstancesMatchingAny(ctx, client, 1, ref.InstanceID, "instance-id")
+	if err != nil {
+		return Result{}, err
+	}
+
+	if len(found.Results) == 0 {
+		return Result{}, fmt.Errorf("instance %s not found in %s", ref.InstanceID, ref.AccountAlias)
+	}
+
+	result := found.Results[0]
+	client.annotate(&result, client.accountID(ctx))
+
+	return result, nil
+}
+
+// Tag is a single tag on an AWS resource
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// InstanceTags returns all of an instance's tags, sorted by key
+func (e *EC2Resolver) InstanceTags(ctx context.Context, ref InstanceRef) ([]Tag, error) {
+	instance, err := e.Instance(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []Tag{}
+	for key := range instance.Metadata {
+		if strings.HasPrefix(key, "tag:") {
+			tags = append(tags, Tag{Key: key[len("tag:"):], Value: instance.GetMetadata(key)})
+		}
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+
+	return tags, nil
+}
+
+// SecurityGroup summarises a security group's rules
+type SecurityGroup struct {
+	ID   string
+	Name string
+
+	// Each rule is described like "tcp 443 from 0.0.0.0/0"
+	Ingress []string
+	Egress  []string
+}
+
+// InstanceSecurityGroups returns the security groups attached to an instance
+func (e *EC2Resolver) InstanceSecurityGroups(ctx context.Context, ref InstanceRef) ([]SecurityGroup, error) {
+	instance, err := e.Instance(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs := instance.Metadata["security_group_ids"]
+	if len(groupIDs) == 0 {
+		return []SecurityGroup{}, nil
+	}
+
+	client, err := e.clientFor(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.accountSearchTimeout)
+	defer cancel()
+
+	output, err := client.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: aws.StringSlice(groupIDs),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []SecurityGroup{}
+	for _, group := range output.SecurityGroups {
+		groups = append(groups, SecurityGroup{
+			ID:      aws.StringValue(group.GroupId),
+			Name:    aws.StringValue(group.GroupName),
+			Ingress: describeIpPermissions(group.IpPermissions, "from"),
+			Egress:  describeIpPermissions(group.IpPermissionsEgress, "to"),
+		})
+	}
+
+	return groups, nil
+}
+
+func describeIpPermissions(permissions []*ec2.IpPermission, direction string) []string {
+	rules := []string{}
+
+	for _, permission := range permissions {
+		protocol := aws.StringValue(permission.IpProtocol)
+		ports := ""
+
+		switch {
+		case protocol == "-1":
+			protocol = "all traffic"
+		case permission.FromPort == nil:
+		case aws.Int64Value(permission.FromPort) == aws.Int64Value(permission.ToPort):
+			ports = fmt.Sprintf(" %d", aws.Int64Value(permission.FromPort))
+		default:
+			ports = fmt.Sprintf(" %d-%d", aws.Int64Value(permission.FromPort), aws.Int64Value(permission.ToPort))
+		}
+
+		sources := []string{}
+		for _, ipRange := range permission.IpRanges {
+			sources = append(sources, aws.StringValue(ipRange.CidrIp))
+		}
+		for _, ipRange := range permission.Ipv6Ranges {
+			sources = append(sources, aws.StringValue(ipRange.CidrIpv6))
+		}
+		for _, pair := range permission.UserIdGroupPairs {
+			sources = append(sources, aws.StringValue(pair.GroupId))
+		}
+		for _, prefixList := range permission.PrefixListIds {
+			sources = append(sources, aws.StringValue(prefixList.PrefixListId))
+		}
+
+		rules = append(rules, fmt.Sprintf("%s%s %s %s", protocol, ports, direction, strings.Join(sources, ", ")))
+	}
+
+	return rules
+}
+
+// InstanceConsoleOutput returns the most recent output from the instance's
+// serial console. AWS only keeps the last 64KB
+func (e *EC2Resolver) InstanceConsoleOutput(ctx context.Context, ref InstanceRef) (string, error) {
+	client, err := e.clientFor(ref)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.accountSearchTimeout)
+	defer cancel()
+
+	output, err := client.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(ref.InstanceID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(aws.StringValue(output.Output))
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
+}
@@ -0,0 +1,141 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestParseInstanceRef(t *testing.T) {
+	ref := InstanceRef{AccountAlias: "PRODUCTION", Region: "eu-west-2", InstanceID: "i-0123456789abcdef0"}
+
+	parsed, err := ParseInstanceRef(ref.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != ref {
+		t.Errorf("expected %#v, got %#v", ref, parsed)
+	}
+
+	for _, invalid := range []string{"", "i-0123456789abcdef0", "PRODUCTION|eu-west-2", "|eu-west-2|i-0123456789abcdef0"} {
+		if _, err := ParseInstanceRef(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestEC2ResolverInstanceDetails(t *testing.T) {
+	instance := makeInstance("i-0123456789abcdef0", "10.1.2.3")
+	instance.Tags = []*ec2.Tag{
+		&ec2.Tag{Key: aws.String("Role"), Value: aws.String("api")},
+		&ec2.Tag{Key: aws.String("Environment"), Value: aws.String("production")},
+	}
+	instance.SecurityGroups = []*ec2.GroupIdentifier{
+		&ec2.GroupIdentifier{GroupId: aws.String("sg-12345678"), GroupName: aws.String("api")},
+	}
+
+	sdk := &fakeEc2{
+		instances: map[string][]*ec2.Instance{
+			"instance-id=i-0123456789abcdef0": []*ec2.Instance{instance},
+		},
+		securityGroups: []*ec2.SecurityGroup{
+			&ec2.SecurityGroup{
+				GroupId:   aws.String("sg-12345678"),
+				GroupName: aws.String("api"),
+				IpPermissions: []*ec2.IpPermission{
+					&ec2.IpPermission{
+						IpProtocol: aws.String("tcp"),
+						FromPort:   aws.Int64(443),
+						ToPort:     aws.Int64(443),
+						IpRanges:   []*ec2.IpRange{&ec2.IpRange{CidrIp: aws.String("0.0.0.0/0")}},
+					},
+				},
+				IpPermissionsEgress: []*ec2.IpPermission{
+					&ec2.IpPermission{
+						IpProtocol: aws.String("-1"),
+						IpRanges:   []*ec2.IpRange{&ec2.IpRange{CidrIp: aws.String("0.0.0.0/0")}},
+					},
+				},
+			},
+		},
+		consoleOutput: base64.StdEncoding.EncodeToString([]byte("Cloud-init finished\n")),
+	}
+
+	resolver := &EC2Resolver{
+		clients: []ec2Client{ec2Client{
+			alias:           "PRODUCTION",
+			region:          "eu-west-2",
+			ec2SDK:          sdk,
+			identity:        &accountIdentity{client: fakeSts{account: "123456789012"}},
+			consoleRoleName: "SlashInfraInspection",
+		}},
+		accountSearchTimeout: time.Second,
+	}
+	ref := InstanceRef{AccountAlias: "PRODUCTION", Region: "eu-west-2", InstanceID: "i-0123456789abcdef0"}
+
+	t.Run("It links to the instance's account like search results do", func(t *testing.T) {
+		instance, err := resolver.Instance(context.Background(), ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if instance.AccountID != "123456789012" {
+			t.Errorf("unexpected account ID %q", instance.AccountID)
+		}
+		if link := instance.Links["switch_role"]; link != switchRoleLink("123456789012", "SlashInfraInspection", "PRODUCTION") {
+			t.Errorf("unexpected switch role link %q", link)
+		}
+	})
+
+	t.Run("It returns the instance's tags sorted by key", func(t *testing.T) {
+		tags, err := resolver.InstanceTags(context.Background(), ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []Tag{{Key: "Environment", Value: "production"}, {Key: "Role", Value: "api"}}
+		if !reflect.DeepEqual(tags, expected) {
+			t.Errorf("expected %#v, got %#v", expected, tags)
+		}
+	})
+
+	t.Run("It describes the rules in the instance's security groups", func(t *testing.T) {
+		groups, err := resolver.InstanceSecurityGroups(context.Background(), ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []SecurityGroup{{
+			ID:      "sg-12345678",
+			Name:    "api",
+			Ingress: []string{"tcp 443 from 0.0.0.0/0"},
+			Egress:  []string{"all traffic to 0.0.0.0/0"},
+		}}
+		if !reflect.DeepEqual(groups, expected) {
+			t.Errorf("expected %#v, got %#v", expected, groups)
+		}
+	})
+
+	t.Run("It decodes the console output", func(t *testing.T) {
+		output, err := resolver.InstanceConsoleOutput(context.Background(), ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if output != "Cloud-init finished\n" {
+			t.Errorf("unexpected console output %q", output)
+		}
+	})
+
+	t.Run("It refuses accounts that aren't configured", func(t *testing.T) {
+		_, err := resolver.InstanceTags(context.Background(), InstanceRef{AccountAlias: "STAGING", Region: "eu-west-2", InstanceID: "i-0123456789abcdef0"})
+		if err != ErrUnknownAccount {
+			t.Errorf("expected ErrUnknownAccount, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,546 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// fakeEc2 returns instances and addresses based on the name and value of
+// the first filter in each call
+type fakeEc2 struct {
+	instances map[string][]*ec2.Instance
+	addresses map[string][]*ec2.Address
+	calls     []string
+
+	// How long each call takes to respond
+	delay time.Duration
+
+	// Returned by every call, if set
+	err error
+
+	// How many instances to return in each page of results
+	pageSize int
+
+	// How long it takes to fetch each page after the first
+	pageDelay time.Duration
+
+	securityGroups []*ec2.SecurityGroup
+	consoleOutput  string
+}
+
+func (f *fakeEc2) DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	filter := *input.Filters[0].Name + "=" + *input.Filters[0].Values[0]
+	f.calls = append(f.calls, filter)
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{
+			&ec2.Reservation{Instances: f.instances[filter]},
+		},
+	}, nil
+}
+
+func (f *fakeEc2) DescribeInstancesPagesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	output, err := f.DescribeInstancesWithContext(ctx, input, opts...)
+	if err != nil {
+		return err
+	}
+
+	instances := output.Reservations[0].Instances
+	pageSize := f.pageSize
+	if pageSize <= 0 {
+		pageSize = len(instances) + 1
+	}
+
+	for start := 0; start == 0 || start < len(instances); start += pageSize {
+		end := start + pageSize
+		if end > len(instances) {
+			end = len(instances)
+		}
+
+		if start > 0 {
+			select {
+			case <-time.After(f.pageDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		page := &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{&ec2.Reservation{Instances: instances[start:end]}},
+		}
+		if !fn(page, end == len(instances)) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeEc2) DescribeAddressesWithContext(ctx aws.Context, input *ec2.DescribeAddressesInput, opts ...request.Option) (*ec2.DescribeAddressesOutput, error) {
+	filter := *input.Filters[0].Name + "=" + *input.Filters[0].Values[0]
+	f.calls = append(f.calls, filter)
+
+	return &ec2.DescribeAddressesOutput{Addresses: f.addresses[filter]}, nil
+}
+
+func (f *fakeEc2) DescribeSecurityGroupsWithContext(ctx aws.Context, input *ec2.DescribeSecurityGroupsInput, opts ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.calls = append(f.calls, "group-id="+strings.Join(aws.StringValueSlice(input.GroupIds), ","))
+
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: f.securityGroups}, nil
+}
+
+func (f *fakeEc2) GetConsoleOutputWithContext(ctx aws.Context, input *ec2.GetConsoleOutputInput, opts ...request.Option) (*ec2.GetConsoleOutputOutput, error) {
+	f.calls = append(f.calls, "console-output="+aws.StringValue(input.InstanceId))
+
+	return &ec2.GetConsoleOutputOutput{Output: aws.String(f.consoleOutput)}, nil
+}
+
+type fakeSts struct {
+	account string
+
+	// If set, GetCallerIdentity doesn't answer until this is closed
+	blocked chan struct{}
+}
+
+func (f fakeSts) GetCallerIdentityWithContext(ctx aws.Context, input *sts.GetCallerIdentityInput, opts ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	if f.blocked != nil {
+		select {
+		case <-f.blocked:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return &sts.GetCallerIdentityOutput{Account: aws.String(f.account)}, nil
+}
+
+func makeInstance(id, privateIP string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:   aws.String(id),
+		ImageId:      aws.String("ami-12345678"),
+		InstanceType: aws.String("t3.micro"),
+		State:        &ec2.InstanceState{Name: aws.String("running")},
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("eu-west-2a")},
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			&ec2.InstanceNetworkInterface{
+				PrivateIpAddresses: []*ec2.InstancePrivateIpAddress{
+					&ec2.InstancePrivateIpAddress{PrivateIpAddress: aws.String(privateIP)},
+				},
+			},
+		},
+	}
+}
+
+func TestFindEC2InstancesByPrivateIP(t *testing.T) {
+	t.Run("It searches primary and secondary private IPs without duplicating instances", func(t *testing.T) {
+		client := &fakeEc2{
+			instances: map[string][]*ec2.Instance{
+				"private-ip-address=10.1.2.3": []*ec2.Instance{
+					makeInstance("i-0123456789abcdef0", "10.1.2.3"),
+				},
+				"network-interface.addresses.private-ip-address=10.1.2.3": []*ec2.Instance{
+					makeInstance("i-0123456789abcdef0", "10.1.2.3"),
+					makeInstance("i-0fedcba9876543210", "10.1.2.3"),
+				},
+			},
+		}
+
+		result, err := findEC2InstancesByPrivateIP(context.Background(), ec2Client{ec2SDK: client}, "10.1.2.3", DefaultMaxResults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(client.calls) != 2 {
+			t.Errorf("expected 2 calls to the EC2 API, got %v", client.calls)
+		}
+
+		if result.Kind != "ec2.instance" {
+			t.Errorf("unexpected result kind %q", result.Kind)
+		}
+
+		if len(result.Results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(result.Results))
+		}
+
+		if id := result.Results[1].GetMetadata("instance_id"); id != "i-0fedcba9876543210" {
+			t.Errorf("unexpected instance %q", id)
+		}
+	})
+}
+
+func TestFindEC2InstancesBySecurityGroupID(t *testing.T) {
+	t.Run("It searches the groups of every network interface", func(t *testing.T) {
+		client := &fakeEc2{
+			instances: map[string][]*ec2.Instance{
+				"instance.group-id=sg-12345678": []*ec2.Instance{
+					makeInstance("i-0123456789abcdef0", "10.1.2.3"),
+				},
+				"network-interface.group-id=sg-12345678": []*ec2.Instance{
+					makeInstance("i-0fedcba9876543210", "10.1.2.4"),
+				},
+			},
+		}
+
+		result, err := findEC2InstancesBySecurityGroupID(context.Background(), ec2Client{ec2SDK: client}, "sg-12345678", DefaultMaxResults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Results) != 2 {
+			t.Errorf("expected 2 results, got %d", len(result.Results))
+		}
+	})
+}
+
+func TestFindUnattachedElasticIPs(t *testing.T) {
+	t.Run("It only returns addresses that are not attached to an instance", func(t *testing.T) {
+		client := &fakeEc2{
+			addresses: map[string][]*ec2.Address{
+				"public-ip=3.8.1.2": []*ec2.Address{
+					&ec2.Address{PublicIp: aws.String("3.8.1.2"), AllocationId: aws.String("eipalloc-attached"), InstanceId: aws.String("i-0123456789abcdef0")},
+					&ec2.Address{PublicIp: aws.String("3.8.1.2"), AllocationId: aws.String("eipalloc-unattached")},
+				},
+			},
+		}
+
+		result, err := findUnattachedElasticIPs(context.Background(), ec2Client{ec2SDK: client}, "3.8.1.2", DefaultMaxResults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(result.Results))
+		}
+
+		eip := result.Results[0]
+		if eip.GetMetadata("allocation_id") != "eipalloc-unattached" {
+			t.Errorf("unexpected allocation ID %q", eip.GetMetadata("allocation_id"))
+		}
+	})
+}
+
+func TestFindEC2InstancesByName(t *testing.T) {
+	t.Run("It passes wildcards through to the Name tag filter", func(t *testing.T) {
+		client := &fakeEc2{
+			instances: map[string][]*ec2.Instance{
+				"tag:Name=api-worker-*": []*ec2.Instance{
+					makeInstance("i-0123456789abcdef0", "10.1.2.3"),
+					makeInstance("i-0fedcba9876543210", "10.1.2.4"),
+				},
+			},
+		}
+
+		result, err := findEC2InstancesByName(context.Background(), ec2Client{ec2SDK: client}, "api-worker-*", DefaultMaxResults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Results) != 2 {
+			t.Errorf("expected 2 results, got %d", len(result.Results))
+		}
+	})
+
+	t.Run("It pages through every result but only keeps up to the limit", func(t *testing.T) {
+		instances := []*ec2.Instance{}
+		for i := 0; i < 7; i++ {
+			instances = append(instances, makeInstance(fmt.Sprintf("i-0123456789abcdef%d", i), "10.1.2.3"))
+		}
+
+		client := &fakeEc2{
+			pageSize:  2,
+			instances: map[string][]*ec2.Instance{"tag:Name=api-worker-*": instances},
+		}
+
+		result, err := findEC2InstancesByName(context.Background(), ec2Client{ec2SDK: client}, "api-worker-*", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Results) != 3 || result.Total != 7 || !result.Truncated() {
+			t.Errorf("expected 3 of 7 results, got %d of %d", len(result.Results), result.Total)
+		}
+	})
+}
+
+func TestEC2ResolverSearch(t *testing.T) {
+	fastAccount := func(alias string) ec2Client {
+		return ec2Client{
+			alias: alias,
+			ec2SDK: &fakeEc2{
+				instances: map[string][]*ec2.Instance{
+					"instance-id=i-0123456789abcdef0": []*ec2.Instance{
+						makeInstance("i-0123456789abcdef0", "10.1.2.3"),
+					},
+				},
+			},
+		}
+	}
+	slowAccount := func(alias string) ec2Client {
+		return ec2Client{alias: alias, ec2SDK: &fakeEc2{delay: time.Second}}
+	}
+
+	t.Run("It records which account and region each result came from", func(t *testing.T) {
+		client := fastAccount("PRODUCTION")
+		client.region = "eu-west-2"
+		client.identity = &accountIdentity{client: fakeSts{account: "123456789012"}}
+
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{client},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: time.Second,
+		}
+
+		results := resolver.Search(context.Background(), Query{Text: "i-0123456789abcdef0"})
+
+		if len(results.Sets) != 1 || len(results.Sets[0].Results) != 1 {
+			t.Fatalf("unexpected results %#v", results.Sets)
+		}
+
+		result := results.Sets[0].Results[0]
+		if result.AccountID != "123456789012" {
+			t.Errorf("unexpected account ID %q", result.AccountID)
+		}
+
+		if location := result.Location(); location != "PRODUCTION / eu-west-2" {
+			t.Errorf("unexpected location %q", location)
+		}
+	})
+
+	t.Run("It keeps what it found if an account runs out of time while paging", func(t *testing.T) {
+		instances := []*ec2.Instance{}
+		for i := 0; i < 7; i++ {
+			instances = append(instances, makeInstance(fmt.Sprintf("i-0123456789abcdef%d", i), "10.1.2.3"))
+		}
+
+		client := ec2Client{alias: "PRODUCTION", ec2SDK: &fakeEc2{
+			pageSize:  2,
+			pageDelay: time.Second,
+			instances: map[string][]*ec2.Instance{"tag:Name=api-worker-*": instances},
+		}}
+
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{client},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: 50 * time.Millisecond,
+			maxResults:           5,
+		}
+
+		results := resolver.Search(context.Background(), Query{Text: NameTagQueryPrefix + "api-worker-*"})
+
+		if len(results.Accounts) != 1 || results.Accounts[0].State != AccountTimedOut {
+			t.Errorf("expected the account to be reported as timed out, got %#v", results.Accounts)
+		}
+		if len(results.Sets) != 1 {
+			t.Fatalf("expected the first page of results to be kept, got %#v", results.Sets)
+		}
+
+		set := results.Sets[0]
+		if len(set.Results) != 2 || set.Total != 2 || !set.Incomplete || !set.Truncated() {
+			t.Errorf("expected an incomplete set of 2 results, got %d of %d (incomplete: %t)", len(set.Results), set.Total, set.Incomplete)
+		}
+	})
+
+	t.Run("It doesn't wait for the account ID to show results", func(t *testing.T) {
+		blocked := make(chan struct{})
+		defer close(blocked)
+
+		client := fastAccount("PRODUCTION")
+		client.identity = &accountIdentity{client: fakeSts{account: "123456789012", blocked: blocked}}
+
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{client},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: 100 * time.Millisecond,
+		}
+
+		results := resolver.Search(context.Background(), Query{Text: "i-0123456789abcdef0"})
+
+		if len(results.Accounts) != 1 || results.Accounts[0].State != AccountOK {
+			t.Errorf("expected the account to be searched, got %#v", results.Accounts)
+		}
+		if len(results.Sets) != 1 || len(results.Sets[0].Results) != 1 {
+			t.Fatalf("unexpected results %#v", results.Sets)
+		}
+		if _, ok := results.Sets[0].Results[0].Links["switch_role"]; ok {
+			t.Error("expected no switch role link without the account ID")
+		}
+	})
+
+	t.Run("It limits the number of results across all accounts", func(t *testing.T) {
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{fastAccount("DEV"), fastAccount("STAGING"), fastAccount("PRODUCTION")},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: time.Second,
+			maxResults:           2,
+		}
+
+		results := resolver.Search(context.Background(), Query{Text: "i-0123456789abcdef0"})
+
+		if len(results.Sets) != 1 {
+			t.Fatalf("expected results to be merged into one set, got %#v", results.Sets)
+		}
+
+		if set := results.Sets[0]; len(set.Results) != 2 || set.Total != 3 {
+			t.Errorf("expected 2 of 3 results, got %d of %d", len(set.Results), set.Total)
+		}
+	})
+
+	t.Run("It only searches the accounts and regions in the query", func(t *testing.T) {
+		dev, staging, production := fastAccount("DEV"), fastAccount("STAGING"), fastAccount("PRODUCTION")
+		dev.region, staging.region, production.region = "eu-west-2", "us-east-1", "eu-west-2"
+
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{dev, staging, production},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: time.Second,
+			maxResults:           10,
+		}
+
+		results := resolver.Search(context.Background(), Query{
+			Text:     "i-0123456789abcdef0",
+			Accounts: []string{"staging", "production"},
+			Regions:  []string{"eu-west-2"},
+		})
+
+		if len(results.Accounts) != 1 || results.Accounts[0].Alias != "PRODUCTION" {
+			t.Errorf("expected only PRODUCTION to be searched, got %v", results.Accounts)
+		}
+
+		results = resolver.Search(context.Background(), Query{Text: "i-0123456789abcdef0", Limit: 1})
+
+		if set := results.Sets[0]; len(set.Results) != 1 || set.Total != 3 {
+			t.Errorf("expected 1 of 3 results, got %d of %d", len(set.Results), set.Total)
+		}
+	})
+
+	t.Run("It reports accounts that take longer than the account timeout", func(t *testing.T) {
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{slowAccount("STAGING"), fastAccount("PRODUCTION")},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: 10 * time.Millisecond,
+		}
+
+		results := resolver.Search(context.Background(), Query{Text: "i-0123456789abcdef0"})
+
+		if len(results.Sets) != 1 || len(results.Sets[0].Results) != 1 {
+			t.Errorf("expected the fast account's result, got %#v", results.Sets)
+		}
+
+		timedOut := results.AccountsWith(AccountTimedOut)
+		if len(timedOut) != 1 || timedOut[0].Alias != "STAGING" {
+			t.Errorf("unexpected timed out accounts %v", timedOut)
+		}
+	})
+
+	t.Run("It returns what it has found when the overall timeout is reached", func(t *testing.T) {
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{fastAccount("PRODUCTION"), slowAccount("STAGING"), slowAccount("DEV")},
+			searchTimeout:        20 * time.Millisecond,
+			accountSearchTimeout: time.Minute,
+		}
+
+		started := time.Now()
+		results := resolver.Search(context.Background(), Query{Text: "i-0123456789abcdef0"})
+
+		if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+			t.Errorf("expected search to give up after the overall timeout, took %s", elapsed)
+		}
+
+		if len(results.Sets) != 1 {
+			t.Errorf("expected the fast account's result, got %#v", results.Sets)
+		}
+
+		timedOut := results.AccountsWith(AccountTimedOut)
+		if len(timedOut) != 2 || timedOut[0].Alias != "STAGING" || timedOut[1].Alias != "DEV" {
+			t.Errorf("unexpected timed out accounts %v", timedOut)
+		}
+	})
+
+	t.Run("It reports the AWS error code for accounts that fail", func(t *testing.T) {
+		resolver := &EC2Resolver{
+			clients: []ec2Client{
+				fastAccount("STAGING"),
+				ec2Client{
+					alias:  "PRODUCTION",
+					ec2SDK: &fakeEc2{err: awserr.New("AccessDenied", "not authorized to perform ec2:DescribeInstances", nil)},
+				},
+			},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: time.Second,
+		}
+
+		results := resolver.Search(context.Background(), Query{Text: "i-0123456789abcdef0"})
+
+		if len(results.Accounts) != 2 {
+			t.Fatalf("expected the status of 2 accounts, got %v", results.Accounts)
+		}
+
+		if results.Accounts[0].State != AccountOK {
+			t.Errorf("unexpected status for STAGING %v", results.Accounts[0])
+		}
+
+		if results.Accounts[1].State != AccountError || results.Accounts[1].Reason != "AccessDenied" {
+			t.Errorf("unexpected status for PRODUCTION %v", results.Accounts[1])
+		}
+	})
+
+	t.Run("It skips accounts in a different region to the one in a DNS name", func(t *testing.T) {
+		client := &fakeEc2{}
+		resolver := &EC2Resolver{
+			clients:              []ec2Client{ec2Client{alias: "DEV", region: "us-east-1", ec2SDK: client}},
+			searchTimeout:        time.Second,
+			accountSearchTimeout: time.Second,
+		}
+
+		results := resolver.Search(context.Background(), Query{Text: "ip-10-1-2-3.eu-west-2.compute.internal"})
+
+		if len(client.calls) != 0 {
+			t.Errorf("did not expect the account to be searched, got %v", client.calls)
+		}
+
+		if len(results.AccountsWith(AccountSkipped)) != 1 {
+			t.Errorf("expected the account to be skipped, got %v", results.Accounts)
+		}
+	})
+}
+
+func TestEC2ResolverDescribe(t *testing.T) {
+	described := map[IdentifierType]bool{}
+
+	for _, example := range (&EC2Resolver{}).Describe().Examples {
+		identifier := ClassifyQuery(example.Query)
+		if _, ok := ec2Finders[identifier.Type]; !ok {
+			t.Errorf("example %q for %s can't be searched for", example.Query, example.Description)
+		}
+
+		if described[identifier.Type] {
+			t.Errorf("%s is described more than once", identifier.Type)
+		}
+		described[identifier.Type] = true
+	}
+
+	for identifierType := range ec2Finders {
+		if !described[identifierType] {
+			t.Errorf("expected an example of %s", identifierType)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package search
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Links to the AWS console need to include the region the resource is in,
+// otherwise the console will show whichever region the user last used
+
+func ec2ConsoleLink(region, search string) string {
+	return fmt.Sprintf("https://console.aws.amazon.com/ec2/v2/home?region=%s#Instances:search=%s;sort=desc:launchTime", region, search)
+}
+
+func ec2ConfigTimelineLink(region, instanceId string) string {
+	return fmt.Sprintf("https://console.aws.amazon.com/config/home?region=%s#/timeline/AWS::EC2::Instance/%s/configuration", region, instanceId)
+}
+
+func elasticIPConsoleLink(region, publicIP string) string {
+	return fmt.Sprintf("https://console.aws.amazon.com/ec2/v2/home?region=%s#Addresses:search=%s", region, publicIP)
+}
+
+// switchRoleLink builds a link that switches the user's console session into
+// the role in the given account. Returns an empty string if we don't know
+// enough about the account to build the link
+func switchRoleLink(accountID, roleName, displayName string) string {
+	if accountID == "" || roleName == "" {
+		return ""
+	}
+
+	params := url.Values{}
+	params.Set("account", accountID)
+	params.Set("roleName", roleName)
+	if displayName != "" {
+		params.Set("displayName", displayName)
+	}
+
+	return "https://signin.aws.amazon.com/switchrole?" + params.Encode()
+}
+
+// roleNameFromArn extracts the name of a role from its ARN, e.g.
+// arn:aws:iam::123456789012:role/SlashInfraInspection. If the role has a path
+// it's included, e.g. some/path/SlashInfraAccess, as the console can't find
+// the role without it
+func roleNameFromArn(roleArn string) string {
+	parts := strings.SplitN(roleArn, ":", 6)
+	if len(parts) != 6 || !strings.HasPrefix(parts[5], "role/") {
+		return ""
+	}
+
+	return strings.TrimPrefix(parts[5], "role/")
+}
@@ -0,0 +1,35 @@
+package search
+
+import "testing"
+
+func TestSwitchRoleLink(t *testing.T) {
+	t.Run("It links to the role in the account", func(t *testing.T) {
+		link := switchRoleLink("123456789012", "SlashInfraInspection", "PRODUCTION")
+
+		expected := "https://signin.aws.amazon.com/switchrole?account=123456789012&displayName=PRODUCTION&roleName=SlashInfraInspection"
+		if link != expected {
+			t.Errorf("unexpected link %q", link)
+		}
+	})
+
+	t.Run("It doesn't build a link without an account ID", func(t *testing.T) {
+		if link := switchRoleLink("", "SlashInfraInspection", "PRODUCTION"); link != "" {
+			t.Errorf("unexpected link %q", link)
+		}
+	})
+}
+
+func TestRoleNameFromArn(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:iam::123456789012:role/SlashInfraInspection":       "SlashInfraInspection",
+		"arn:aws:iam::123456789012:role/some/path/SlashInfraAccess": "some/path/SlashInfraAccess",
+		"arn:aws:iam::123456789012:user/slash-infra":                "",
+		"not-an-arn": "",
+	}
+
+	for arn, expected := range cases {
+		if actual := roleNameFromArn(arn); actual != expected {
+			t.Errorf("roleNameFromArn(%q) = %q, expected %q", arn, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package search
+
+import (
+	"context"
+	"sync"
+)
+
+// Progress describes how far through a search we are
+type Progress struct {
+	// How many accounts have finished being searched, whether or not they
+	// found anything
+	Searched int
+	Total    int
+}
+
+// ProgressFunc is called each time a search makes progress. It may be
+// called from several goroutines at once
+type ProgressFunc func(Progress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that reports the progress of any search
+// run with it to fn, e.g. so that we can tell the user how many accounts
+// we've searched so far
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func reportProgress(ctx context.Context, progress Progress) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(progress)
+	}
+}
+
+// combinedProgress adds up the progress of several searches running at the
+// same time, such as each resolver in a registry, and reports the total
+type combinedProgress struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	searches []Progress
+}
+
+func newCombinedProgress(ctx context.Context, searches int) *combinedProgress {
+	return &combinedProgress{ctx: ctx, searches: make([]Progress, searches)}
+}
+
+// contextFor returns a context that reports the progress of search i
+func (c *combinedProgress) contextFor(i int) context.Context {
+	return WithProgress(c.ctx, func(progress Progress) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.searches[i] = progress
+
+		total := Progress{}
+		for _, search := range c.searches {
+			total.Searched += search.Searched
+			total.Total += search.Total
+		}
+
+		reportProgress(c.ctx, total)
+	})
+}
@@ -0,0 +1,244 @@
+package search
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// This is 17 characters plus the "i-" prefix
+const ExactEc2InstanceIDLength = 19
+
+// Queries starting with this prefix search instances by their Name tag
+const NameTagQueryPrefix = "name:"
+
+// IdentifierType describes what kind of thing a query refers to
+type IdentifierType string
+
+const (
+	IdentifierUnknown           IdentifierType = ""
+	IdentifierEc2InstanceID     IdentifierType = "ec2.instance_id"
+	IdentifierPrivateIP         IdentifierType = "ip.private"
+	IdentifierPublicIP          IdentifierType = "ip.public"
+	IdentifierNameTag           IdentifierType = "ec2.name_tag"
+	IdentifierEc2PrivateDNSName IdentifierType = "ec2.private_dns_name"
+	IdentifierEc2PublicDNSName  IdentifierType = "ec2.public_dns_name"
+
+	IdentifierEc2NetworkInterfaceID IdentifierType = "ec2.network_interface_id"
+	IdentifierEc2SecurityGroupID    IdentifierType = "ec2.security_group_id"
+	IdentifierEc2SubnetID           IdentifierType = "ec2.subnet_id"
+)
+
+// Identifier is a query that has been recognised as a particular type of
+// identifier
+type Identifier struct {
+	Type IdentifierType
+
+	// The value to search for. This may differ from the original query,
+	// e.g. it won't include the `name:` prefix
+	Value string
+
+	// Some identifiers (e.g. EC2 DNS names) tell us which region the
+	// resource is in. This is empty if the region isn't known
+	Region string
+}
+
+var (
+	ec2InstanceIDPattern = regexp.MustCompile(`^i-[0-9a-f]+$`)
+
+	// e.g. ip-10-1-2-3.eu-west-2.compute.internal, or ip-10-1-2-3.ec2.internal
+	// for instances in us-east-1
+	ec2PrivateDNSNamePattern = regexp.MustCompile(`^ip-(\d{1,3}-\d{1,3}-\d{1,3}-\d{1,3})\.(?:([a-z]{2}(?:-gov)?-[a-z]+-\d)\.compute|ec2)\.internal$`)
+
+	// e.g. ec2-3-8-1-2.eu-west-2.compute.amazonaws.com, or
+	// ec2-54-1-2-3.compute-1.amazonaws.com for instances in us-east-1
+	ec2PublicDNSNamePattern = regexp.MustCompile(`^ec2-(\d{1,3}-\d{1,3}-\d{1,3}-\d{1,3})\.(?:([a-z]{2}(?:-gov)?-[a-z]+-\d)\.compute|compute-1)\.amazonaws\.com$`)
+
+	// The hostname an instance gives itself, e.g. ip-10-1-2-3. This doesn't
+	// tell us the region, but does tell us the private IP
+	ec2ShortHostnamePattern = regexp.MustCompile(`^ip-(\d{1,3}-\d{1,3}-\d{1,3}-\d{1,3})$`)
+
+	// Other EC2 resource IDs, which have either 8 or 17 hex characters
+	// depending on when the resource was created
+	ec2ResourceIDPatterns = map[IdentifierType]*regexp.Regexp{
+		IdentifierEc2NetworkInterfaceID: regexp.MustCompile(`^eni-(?:[0-9a-f]{8}|[0-9a-f]{17})$`),
+		IdentifierEc2SecurityGroupID:    regexp.MustCompile(`^sg-(?:[0-9a-f]{8}|[0-9a-f]{17})$`),
+		IdentifierEc2SubnetID:           regexp.MustCompile(`^subnet-(?:[0-9a-f]{8}|[0-9a-f]{17})$`),
+	}
+
+	// Instance IDs anywhere in some text, e.g. a chat message
+	ec2InstanceIDInTextPattern = regexp.MustCompile(`\bi-[0-9a-f]{17}\b`)
+
+	// Anything in some text that might be an identifier. Each candidate is
+	// checked with ClassifyQuery, so this can be loose
+	identifierInTextPattern = regexp.MustCompile(strings.Join([]string{
+		`\b(?:i|eni|sg|subnet)-[0-9a-f]+\b`,
+		`\b(?:ip|ec2)-\d{1,3}-\d{1,3}-\d{1,3}-\d{1,3}(?:\.[a-z0-9-]+)*`,
+		`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`,
+	}, "|"))
+)
+
+// ClassifyQuery works out what kind of identifier a query is. If the query
+// isn't recognised the Identifier's Type will be IdentifierUnknown
+func ClassifyQuery(query string) Identifier {
+	query = strings.TrimSpace(query)
+
+	if len(query) > len(NameTagQueryPrefix) && strings.EqualFold(query[:len(NameTagQueryPrefix)], NameTagQueryPrefix) {
+		// Tag values are case sensitive, so we leave the pattern as is
+		pattern := strings.TrimSpace(query[len(NameTagQueryPrefix):])
+		if pattern == "" {
+			return Identifier{Type: IdentifierUnknown, Value: query}
+		}
+
+		return Identifier{Type: IdentifierNameTag, Value: pattern}
+	}
+
+	// Hostnames are case insensitive, and may be fully qualified
+	hostname := strings.TrimSuffix(strings.ToLower(query), ".")
+
+	// The EC2 API does not allow you to do substring searches, so instance
+	// IDs must be complete
+	if len(hostname) == ExactEc2InstanceIDLength && ec2InstanceIDPattern.MatchString(hostname) {
+		return Identifier{Type: IdentifierEc2InstanceID, Value: hostname}
+	}
+
+	for identifierType, pattern := range ec2ResourceIDPatterns {
+		if pattern.MatchString(hostname) {
+			return Identifier{Type: identifierType, Value: hostname}
+		}
+	}
+
+	if isPrivateIPv4(query) {
+		return Identifier{Type: IdentifierPrivateIP, Value: query}
+	}
+
+	if isPublicIPv4(query) {
+		return Identifier{Type: IdentifierPublicIP, Value: query}
+	}
+
+	if match := ec2PrivateDNSNamePattern.FindStringSubmatch(hostname); match != nil && isPrivateIPv4(dashedIPToDotted(match[1])) {
+		return Identifier{Type: IdentifierEc2PrivateDNSName, Value: hostname, Region: regionFromDNSName(match[2])}
+	}
+
+	if match := ec2PublicDNSNamePattern.FindStringSubmatch(hostname); match != nil && isPublicIPv4(dashedIPToDotted(match[1])) {
+		return Identifier{Type: IdentifierEc2PublicDNSName, Value: hostname, Region: regionFromDNSName(match[2])}
+	}
+
+	if match := ec2ShortHostnamePattern.FindStringSubmatch(hostname); match != nil && isPrivateIPv4(dashedIPToDotted(match[1])) {
+		return Identifier{Type: IdentifierPrivateIP, Value: dashedIPToDotted(match[1])}
+	}
+
+	return Identifier{Type: IdentifierUnknown, Value: query}
+}
+
+// FindInstanceIDs returns every instance ID mentioned in some text, in the
+// order they first appear
+func FindInstanceIDs(text string) []string {
+	ids := []string{}
+	seen := map[string]bool{}
+
+	for _, id := range ec2InstanceIDInTextPattern.FindAllString(text, -1) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// FindIdentifiers returns every identifier we can search for in some text,
+// e.g. an alert that mentions several instances. Name tags are never found,
+// as they could be any word
+func FindIdentifiers(text string) []Identifier {
+	identifiers := []Identifier{}
+	seen := map[Identifier]bool{}
+
+	for _, candidate := range identifierInTextPattern.FindAllString(strings.ToLower(text), -1) {
+		// DNS names are often followed by a full stop at the end of a
+		// sentence, so the pattern above may have included it
+		identifier := ClassifyQuery(strings.TrimRight(candidate, ".-"))
+		if identifier.Type == IdentifierUnknown || seen[identifier] {
+			continue
+		}
+
+		seen[identifier] = true
+		identifiers = append(identifiers, identifier)
+	}
+
+	return identifiers
+}
+
+// Query turns the identifier back in to a query that will find it
+func (i Identifier) Query() Query {
+	if i.Type == IdentifierNameTag {
+		return Query{Text: NameTagQueryPrefix + i.Value}
+	}
+
+	return Query{Text: i.Value}
+}
+
+// regionFromDNSName returns the region captured from an EC2 DNS name. Names
+// for us-east-1 use a different format that doesn't include the region
+func regionFromDNSName(captured string) string {
+	if captured == "" {
+		return "us-east-1"
+	}
+
+	return captured
+}
+
+func dashedIPToDotted(ip string) string {
+	return strings.Replace(ip, "-", ".", -1)
+}
+
+// privateIPv4Ranges are the RFC 1918 ranges, plus the shared address space
+// from RFC 6598 which AWS allows as a secondary VPC CIDR
+var privateIPv4Ranges = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+)
+
+func isPrivateIPv4(search string) bool {
+	ip := net.ParseIP(search)
+	if ip == nil || ip.To4() == nil {
+		return false
+	}
+
+	for _, network := range privateIPv4Ranges {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isPublicIPv4(search string) bool {
+	ip := net.ParseIP(search)
+	if ip == nil || ip.To4() == nil {
+		return false
+	}
+
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+
+	return !isPrivateIPv4(search)
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
@@ -0,0 +1,86 @@
+package search
+
+import "testing"
+
+func TestClassifyQuery(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected Identifier
+	}{
+		{"i-0123456789abcdef0", Identifier{Type: IdentifierEc2InstanceID, Value: "i-0123456789abcdef0"}},
+		{" I-0123456789ABCDEF0 ", Identifier{Type: IdentifierEc2InstanceID, Value: "i-0123456789abcdef0"}},
+		{"i-01234567", Identifier{Type: IdentifierUnknown, Value: "i-01234567"}},
+		{"10.1.2.3", Identifier{Type: IdentifierPrivateIP, Value: "10.1.2.3"}},
+		{"172.31.0.10", Identifier{Type: IdentifierPrivateIP, Value: "172.31.0.10"}},
+		{"3.8.1.2", Identifier{Type: IdentifierPublicIP, Value: "3.8.1.2"}},
+		{"127.0.0.1", Identifier{Type: IdentifierUnknown, Value: "127.0.0.1"}},
+		{"fe80::1", Identifier{Type: IdentifierUnknown, Value: "fe80::1"}},
+		{"name:api-worker-*", Identifier{Type: IdentifierNameTag, Value: "api-worker-*"}},
+		{"Name: API-Worker", Identifier{Type: IdentifierNameTag, Value: "API-Worker"}},
+		{"name:", Identifier{Type: IdentifierUnknown, Value: "name:"}},
+		{
+			"ip-10-1-2-3.eu-west-2.compute.internal",
+			Identifier{Type: IdentifierEc2PrivateDNSName, Value: "ip-10-1-2-3.eu-west-2.compute.internal", Region: "eu-west-2"},
+		},
+		{
+			"IP-10-1-2-3.ec2.internal.",
+			Identifier{Type: IdentifierEc2PrivateDNSName, Value: "ip-10-1-2-3.ec2.internal", Region: "us-east-1"},
+		},
+		{
+			"ec2-3-8-1-2.eu-west-2.compute.amazonaws.com",
+			Identifier{Type: IdentifierEc2PublicDNSName, Value: "ec2-3-8-1-2.eu-west-2.compute.amazonaws.com", Region: "eu-west-2"},
+		},
+		{
+			"ec2-54-1-2-3.compute-1.amazonaws.com",
+			Identifier{Type: IdentifierEc2PublicDNSName, Value: "ec2-54-1-2-3.compute-1.amazonaws.com", Region: "us-east-1"},
+		},
+		{"ip-10-1-2-3", Identifier{Type: IdentifierPrivateIP, Value: "10.1.2.3"}},
+		{"ip-999-1-2-3.ec2.internal", Identifier{Type: IdentifierUnknown, Value: "ip-999-1-2-3.ec2.internal"}},
+		{"api-worker-1", Identifier{Type: IdentifierUnknown, Value: "api-worker-1"}},
+		{"eni-0123456789abcdef0", Identifier{Type: IdentifierEc2NetworkInterfaceID, Value: "eni-0123456789abcdef0"}},
+		{"sg-12345678", Identifier{Type: IdentifierEc2SecurityGroupID, Value: "sg-12345678"}},
+		{"SUBNET-0123456789ABCDEF0", Identifier{Type: IdentifierEc2SubnetID, Value: "subnet-0123456789abcdef0"}},
+		{"sg-1234", Identifier{Type: IdentifierUnknown, Value: "sg-1234"}},
+	}
+
+	for _, c := range cases {
+		if actual := ClassifyQuery(c.query); actual != c.expected {
+			t.Errorf("ClassifyQuery(%q) = %#v, expected %#v", c.query, actual, c.expected)
+		}
+	}
+}
+
+func TestFindInstanceIDs(t *testing.T) {
+	ids := FindInstanceIDs("CPU high on i-0123456789abcdef0 and i-0fedcba9876543210 (i-0123456789abcdef0 again), not i-01234567")
+
+	if len(ids) != 2 || ids[0] != "i-0123456789abcdef0" || ids[1] != "i-0fedcba9876543210" {
+		t.Errorf("unexpected instance IDs %#v", ids)
+	}
+}
+
+func TestFindIdentifiers(t *testing.T) {
+	text := `[FIRING] DiskFull on i-0123456789abcdef0 (10.1.2.3, ip-10-1-2-3.eu-west-2.compute.internal).
+Also seen on ec2-3-8-1-2.eu-west-2.compute.amazonaws.com in sg-12345678/subnet-0123456789abcdef0 via eni-0fedcba9876543210.
+Retrying i-0123456789abcdef0, version 1.2.3, see 127.0.0.1`
+
+	expected := []Identifier{
+		{Type: IdentifierEc2InstanceID, Value: "i-0123456789abcdef0"},
+		{Type: IdentifierPrivateIP, Value: "10.1.2.3"},
+		{Type: IdentifierEc2PrivateDNSName, Value: "ip-10-1-2-3.eu-west-2.compute.internal", Region: "eu-west-2"},
+		{Type: IdentifierEc2PublicDNSName, Value: "ec2-3-8-1-2.eu-west-2.compute.amazonaws.com", Region: "eu-west-2"},
+		{Type: IdentifierEc2SecurityGroupID, Value: "sg-12345678"},
+		{Type: IdentifierEc2SubnetID, Value: "subnet-0123456789abcdef0"},
+		{Type: IdentifierEc2NetworkInterfaceID, Value: "eni-0fedcba9876543210"},
+	}
+
+	actual := FindIdentifiers(text)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("identifier %d: expected %#v, got %#v", i, expected[i], actual[i])
+		}
+	}
+}
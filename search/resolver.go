@@ -0,0 +1,293 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Resolver finds resources of one or more kinds that match a query
+type Resolver interface {
+	// CanHandle reports whether the resolver knows how to search for the
+	// query. Resolvers that can't handle a query aren't asked to search
+	// for it
+	CanHandle(query string) bool
+
+	Search(ctx context.Context, query Query) Results
+}
+
+// Query is what to search for, along with anything that narrows the search
+// down
+type Query struct {
+	Text string
+
+	// Only search accounts with these aliases, ignoring case. Every
+	// account is searched if this is empty
+	Accounts []string
+
+	// Only search these regions. Every region is searched if this is empty
+	Regions []string
+
+	// The most results of each kind to return. Resolvers use their own
+	// limit if this is zero, or if it's higher than their own limit
+	Limit int
+}
+
+// IncludesAccount reports whether the query should search an account
+func (q Query) IncludesAccount(alias string) bool {
+	if len(q.Accounts) == 0 {
+		return true
+	}
+
+	for _, account := range q.Accounts {
+		if strings.EqualFold(account, alias) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IncludesRegion reports whether the query should search a region
+func (q Query) IncludesRegion(region string) bool {
+	if len(q.Regions) == 0 {
+		return true
+	}
+
+	for _, r := range q.Regions {
+		if strings.EqualFold(r, region) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Account is an account (and region) that a resolver searches
+type Account struct {
+	Alias  string
+	Region string
+}
+
+// AccountLister is implemented by resolvers that search a fixed set of
+// accounts
+type AccountLister interface {
+	Accounts() []Account
+}
+
+// Example is a kind of query a resolver understands, e.g. "an instance ID"
+// along with a query that it would answer
+type Example struct {
+	Description string
+	Query       string
+}
+
+// Description explains what a resolver searches for, so that help can be
+// generated from the resolvers that are registered
+type Description struct {
+	Name     string
+	Examples []Example
+}
+
+// Describer is implemented by resolvers that can explain what they search
+// for
+type Describer interface {
+	Describe() Description
+}
+
+// Results is everything a search found, along with how the search went in
+// each account
+type Results struct {
+	Sets     []ResultSet
+	Accounts []AccountStatus
+}
+
+// Empty reports whether nothing at all was found
+func (r Results) Empty() bool {
+	for _, set := range r.Sets {
+		if len(set.Results) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// InRegion returns only the results that are in a particular region
+func (r Results) InRegion(region string) Results {
+	filtered := Results{Accounts: r.Accounts}
+
+	for _, set := range r.Sets {
+		inRegion := []Result{}
+		for _, result := range set.Results {
+			if result.Region == region {
+				inRegion = append(inRegion, result)
+			}
+		}
+
+		if len(inRegion) < len(set.Results) {
+			set.Total = len(inRegion)
+		}
+		set.Results = inRegion
+
+		filtered.Sets = append(filtered.Sets, set)
+	}
+
+	return filtered
+}
+
+// AccountsWith returns the accounts that ended up with the given state
+func (r Results) AccountsWith(state AccountState) []AccountStatus {
+	accounts := []AccountStatus{}
+
+	for _, account := range r.Accounts {
+		if account.State == state {
+			accounts = append(accounts, account)
+		}
+	}
+
+	return accounts
+}
+
+type AccountState string
+
+const (
+	AccountOK       AccountState = "ok"
+	AccountError    AccountState = "error"
+	AccountTimedOut AccountState = "timeout"
+	AccountSkipped  AccountState = "skipped"
+
+	// The person searching isn't allowed to search the account from where
+	// they are
+	AccountDenied AccountState = "denied"
+)
+
+// AccountStatus describes how searching a single account went
+type AccountStatus struct {
+	Alias string
+	State AccountState
+
+	// A short explanation of why the account failed or was skipped, e.g.
+	// an AWS error code like "AccessDenied"
+	Reason string
+
+	// The error returned by the account, if State is AccountError
+	Err error
+}
+
+// Registry fans a query out to every resolver that is interested in it
+type Registry struct {
+	resolvers []Resolver
+}
+
+func NewRegistry(resolvers ...Resolver) *Registry {
+	return &Registry{resolvers: resolvers}
+}
+
+// Register adds a resolver to the registry. Results are returned in the
+// order resolvers were registered
+func (r *Registry) Register(resolver Resolver) {
+	r.resolvers = append(r.resolvers, resolver)
+}
+
+// CanHandle reports whether any resolver knows how to search for the query
+func (r *Registry) CanHandle(query string) bool {
+	for _, resolver := range r.resolvers {
+		if resolver.CanHandle(query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolversFor names the resolvers that would search for the query, e.g. so
+// that searches can be audited. Resolvers that can't describe themselves are
+// named after their type
+func (r *Registry) ResolversFor(query string) []string {
+	names := []string{}
+
+	for _, resolver := range r.resolvers {
+		if !resolver.CanHandle(query) {
+			continue
+		}
+
+		if describer, ok := resolver.(Describer); ok {
+			names = append(names, describer.Describe().Name)
+		} else {
+			names = append(names, fmt.Sprintf("%T", resolver))
+		}
+	}
+
+	return names
+}
+
+// Accounts lists every account the registry's resolvers search
+func (r *Registry) Accounts() []Account {
+	accounts := []Account{}
+
+	for _, resolver := range r.resolvers {
+		if lister, ok := resolver.(AccountLister); ok {
+			accounts = append(accounts, lister.Accounts()...)
+		}
+	}
+
+	return accounts
+}
+
+// Describe explains what each resolver in the registry searches for, in the
+// order they were registered
+func (r *Registry) Describe() []Description {
+	descriptions := []Description{}
+
+	for _, resolver := range r.resolvers {
+		if describer, ok := resolver.(Describer); ok {
+			descriptions = append(descriptions, describer.Describe())
+		}
+	}
+
+	return descriptions
+}
+
+// Examples lists every kind of query the registry's resolvers understand
+func (r *Registry) Examples() []Example {
+	examples := []Example{}
+
+	for _, description := range r.Describe() {
+		examples = append(examples, description.Examples...)
+	}
+
+	return examples
+}
+
+// Search runs the query against every interested resolver concurrently, and
+// waits for all of them to finish
+func (r *Registry) Search(ctx context.Context, query Query) Results {
+	resultsByResolver := make([]Results, len(r.resolvers))
+	progress := newCombinedProgress(ctx, len(r.resolvers))
+
+	var wg sync.WaitGroup
+
+	for i, resolver := range r.resolvers {
+		if !resolver.CanHandle(query.Text) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, resolver Resolver) {
+			defer wg.Done()
+			resultsByResolver[i] = resolver.Search(progress.contextFor(i), query)
+		}(i, resolver)
+	}
+
+	wg.Wait()
+
+	results := Results{Sets: []ResultSet{}}
+	for _, resolverResults := range resultsByResolver {
+		results.Sets = append(results.Sets, resolverResults.Sets...)
+		results.Accounts = append(results.Accounts, resolverResults.Accounts...)
+	}
+
+	return results
+}
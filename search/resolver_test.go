@@ -0,0 +1,114 @@
+package search
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type fakeResolver struct {
+	kind      string
+	canHandle bool
+	searched  bool
+
+	// How many accounts the resolver pretends to search
+	accounts int
+}
+
+func (f *fakeResolver) CanHandle(query string) bool {
+	return f.canHandle
+}
+
+func (f *fakeResolver) Search(ctx context.Context, query Query) Results {
+	f.searched = true
+	reportProgress(ctx, Progress{Searched: f.accounts, Total: f.accounts})
+	return Results{Sets: []ResultSet{ResultSet{Kind: f.kind}}}
+}
+
+type describedResolver struct {
+	fakeResolver
+	description Description
+}
+
+func (d *describedResolver) Describe() Description {
+	return d.description
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("It describes every resolver that can describe itself", func(t *testing.T) {
+		ec2 := &describedResolver{description: Description{
+			Name:     "EC2",
+			Examples: []Example{{"an instance ID", "i-0123456789abcdef0"}, {"an IP", "10.1.2.3"}},
+		}}
+		rds := &describedResolver{description: Description{
+			Name:     "RDS",
+			Examples: []Example{{"a database", "db:orders"}},
+		}}
+
+		registry := NewRegistry(ec2, &fakeResolver{}, rds)
+
+		descriptions := registry.Describe()
+		if len(descriptions) != 2 || descriptions[0].Name != "EC2" || descriptions[1].Name != "RDS" {
+			t.Errorf("unexpected descriptions %#v", descriptions)
+		}
+
+		examples := registry.Examples()
+		if len(examples) != 3 || examples[2].Query != "db:orders" {
+			t.Errorf("unexpected examples %#v", examples)
+		}
+
+		ec2.canHandle = true
+		names := registry.ResolversFor("i-0123456789abcdef0")
+		if len(names) != 1 || names[0] != "EC2" {
+			t.Errorf("unexpected resolvers %#v", names)
+		}
+	})
+
+	t.Run("It only searches resolvers that can handle the query", func(t *testing.T) {
+		interested := &fakeResolver{kind: "interested", canHandle: true}
+		uninterested := &fakeResolver{kind: "uninterested"}
+
+		results := NewRegistry(interested, uninterested).Search(context.Background(), Query{Text: "i-0123456789abcdef0"})
+
+		if uninterested.searched {
+			t.Error("did not expect uninterested resolver to be searched")
+		}
+
+		if len(results.Sets) != 1 || results.Sets[0].Kind != "interested" {
+			t.Errorf("unexpected results %#v", results)
+		}
+	})
+
+	t.Run("It returns results in the order resolvers were registered", func(t *testing.T) {
+		registry := NewRegistry()
+		for _, kind := range []string{"first", "second", "third"} {
+			registry.Register(&fakeResolver{kind: kind, canHandle: true})
+		}
+
+		results := registry.Search(context.Background(), Query{Text: "query"})
+
+		if len(results.Sets) != 3 || results.Sets[0].Kind != "first" || results.Sets[2].Kind != "third" {
+			t.Errorf("unexpected results %#v", results)
+		}
+	})
+
+	t.Run("It adds up the progress of every resolver", func(t *testing.T) {
+		var mu sync.Mutex
+		latest := Progress{}
+
+		ctx := WithProgress(context.Background(), func(progress Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			latest = progress
+		})
+
+		NewRegistry(
+			&fakeResolver{canHandle: true, accounts: 2},
+			&fakeResolver{canHandle: true, accounts: 3},
+		).Search(ctx, Query{Text: "query"})
+
+		if latest != (Progress{Searched: 5, Total: 5}) {
+			t.Errorf("unexpected progress %#v", latest)
+		}
+	})
+}
@@ -0,0 +1,412 @@
+package slackutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Limits imposed by slack on Block Kit messages
+// https://api.slack.com/reference/block-kit/blocks
+const (
+	MaxBlocksPerMessage   = 50
+	MaxBlockIDLength      = 255
+	MaxActionIDLength     = 255
+	MaxSectionTextLength  = 3000
+	MaxSectionFields      = 10
+	MaxSectionFieldLength = 2000
+	MaxContextElements    = 10
+	MaxHeaderTextLength   = 150
+	MaxActionsElements    = 25
+	MaxButtonTextLength   = 75
+	MaxButtonValueLength  = 2000
+	MaxURLLength          = 3000
+	MaxPlaceholderLength  = 150
+	MaxSelectOptions      = 100
+	MaxOptionTextLength   = 75
+	MaxOptionValueLength  = 150
+)
+
+const (
+	TextObjectTypePlainText = "plain_text"
+	TextObjectTypeMarkdown  = "mrkdwn"
+
+	ButtonStylePrimary = "primary"
+	ButtonStyleDanger  = "danger"
+)
+
+// Block is a layout block in a Block Kit message
+type Block interface {
+	BlockType() string
+
+	// Validate checks the block is within the limits slack imposes
+	Validate() error
+}
+
+// BlockElement is an interactive element that can be placed in a section's
+// accessory or an actions block
+type BlockElement interface {
+	ElementType() string
+	Validate() error
+}
+
+// ValidateBlocks checks that a message's blocks are within the limits slack
+// imposes, as slack rejects the whole message if any of them are not
+func ValidateBlocks(blocks []Block) error {
+	if len(blocks) > MaxBlocksPerMessage {
+		return fmt.Errorf("message has %d blocks, the limit is %d", len(blocks), MaxBlocksPerMessage)
+	}
+
+	for i, block := range blocks {
+		if err := block.Validate(); err != nil {
+			return fmt.Errorf("block %d (%s): %s", i, block.BlockType(), err)
+		}
+	}
+
+	return nil
+}
+
+// RawBlock is a block that is already encoded as JSON, e.g. one from a
+// message slack sent us in an interaction payload. It is sent back to slack
+// as is
+type RawBlock json.RawMessage
+
+func (r RawBlock) BlockType() string {
+	return r.decode().Type
+}
+
+func (r RawBlock) BlockID() string {
+	return r.decode().BlockID
+}
+
+func (r RawBlock) decode() struct {
+	Type    string `json:"type"`
+	BlockID string `json:"block_id"`
+} {
+	var block struct {
+		Type    string `json:"type"`
+		BlockID string `json:"block_id"`
+	}
+	json.Unmarshal(r, &block)
+	return block
+}
+
+// Validate doesn't check raw blocks, as they usually came from slack in the
+// first place
+func (r RawBlock) Validate() error {
+	return nil
+}
+
+func (r RawBlock) MarshalJSON() ([]byte, error) {
+	return []byte(r), nil
+}
+
+// TextObject is either plain text or markdown
+type TextObject struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Emoji    bool   `json:"emoji,omitempty"`
+	Verbatim bool   `json:"verbatim,omitempty"`
+}
+
+func PlainText(text string) *TextObject {
+	return &TextObject{Type: TextObjectTypePlainText, Text: text, Emoji: true}
+}
+
+func Markdown(text string) *TextObject {
+	return &TextObject{Type: TextObjectTypeMarkdown, Text: text}
+}
+
+func (t *TextObject) validate(maxLength int, plainTextOnly bool) error {
+	if t == nil {
+		return fmt.Errorf("text is missing")
+	}
+
+	if plainTextOnly && t.Type != TextObjectTypePlainText {
+		return fmt.Errorf("text must be %s, not %s", TextObjectTypePlainText, t.Type)
+	}
+
+	return validateLength("text", t.Text, maxLength)
+}
+
+func validateLength(name, value string, maxLength int) error {
+	if length := utf8.RuneCountInString(value); length > maxLength {
+		return fmt.Errorf("%s is %d characters, the limit is %d", name, length, maxLength)
+	}
+
+	return nil
+}
+
+// SectionBlock shows text, optionally in two columns of fields, alongside
+// an optional accessory such as a button
+type SectionBlock struct {
+	BlockID   string        `json:"block_id,omitempty"`
+	Text      *TextObject   `json:"text,omitempty"`
+	Fields    []*TextObject `json:"fields,omitempty"`
+	Accessory BlockElement  `json:"accessory,omitempty"`
+}
+
+func (SectionBlock) BlockType() string { return "section" }
+
+func (s SectionBlock) Validate() error {
+	if err := validateLength("block_id", s.BlockID, MaxBlockIDLength); err != nil {
+		return err
+	}
+
+	if s.Text == nil && len(s.Fields) == 0 {
+		return fmt.Errorf("either text or fields are required")
+	}
+
+	if s.Text != nil {
+		if err := s.Text.validate(MaxSectionTextLength, false); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Fields) > MaxSectionFields {
+		return fmt.Errorf("has %d fields, the limit is %d", len(s.Fields), MaxSectionFields)
+	}
+
+	for i, field := range s.Fields {
+		if err := field.validate(MaxSectionFieldLength, false); err != nil {
+			return fmt.Errorf("field %d: %s", i, err)
+		}
+	}
+
+	if s.Accessory != nil {
+		if err := s.Accessory.Validate(); err != nil {
+			return fmt.Errorf("accessory: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (s SectionBlock) MarshalJSON() ([]byte, error) {
+	type section SectionBlock
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		section
+	}{s.BlockType(), section(s)})
+}
+
+// ContextBlock shows small, secondary text
+type ContextBlock struct {
+	BlockID  string        `json:"block_id,omitempty"`
+	Elements []*TextObject `json:"elements"`
+}
+
+func (ContextBlock) BlockType() string { return "context" }
+
+func (c ContextBlock) Validate() error {
+	if err := validateLength("block_id", c.BlockID, MaxBlockIDLength); err != nil {
+		return err
+	}
+
+	if len(c.Elements) == 0 {
+		return fmt.Errorf("at least one element is required")
+	}
+
+	if len(c.Elements) > MaxContextElements {
+		return fmt.Errorf("has %d elements, the limit is %d", len(c.Elements), MaxContextElements)
+	}
+
+	for i, element := range c.Elements {
+		if err := element.validate(MaxSectionTextLength, false); err != nil {
+			return fmt.Errorf("element %d: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (c ContextBlock) MarshalJSON() ([]byte, error) {
+	type context ContextBlock
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		context
+	}{c.BlockType(), context(c)})
+}
+
+// DividerBlock is a horizontal line
+type DividerBlock struct {
+	BlockID string `json:"block_id,omitempty"`
+}
+
+func (DividerBlock) BlockType() string { return "divider" }
+
+func (d DividerBlock) Validate() error {
+	return validateLength("block_id", d.BlockID, MaxBlockIDLength)
+}
+
+func (d DividerBlock) MarshalJSON() ([]byte, error) {
+	type divider DividerBlock
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		divider
+	}{d.BlockType(), divider(d)})
+}
+
+// HeaderBlock shows plain text in a large, bold font
+type HeaderBlock struct {
+	BlockID string      `json:"block_id,omitempty"`
+	Text    *TextObject `json:"text"`
+}
+
+func (HeaderBlock) BlockType() string { return "header" }
+
+func (h HeaderBlock) Validate() error {
+	if err := validateLength("block_id", h.BlockID, MaxBlockIDLength); err != nil {
+		return err
+	}
+
+	return h.Text.validate(MaxHeaderTextLength, true)
+}
+
+func (h HeaderBlock) MarshalJSON() ([]byte, error) {
+	type header HeaderBlock
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		header
+	}{h.BlockType(), header(h)})
+}
+
+// ActionsBlock holds interactive elements, such as buttons
+type ActionsBlock struct {
+	BlockID  string         `json:"block_id,omitempty"`
+	Elements []BlockElement `json:"elements"`
+}
+
+func (ActionsBlock) BlockType() string { return "actions" }
+
+func (a ActionsBlock) Validate() error {
+	if err := validateLength("block_id", a.BlockID, MaxBlockIDLength); err != nil {
+		return err
+	}
+
+	if len(a.Elements) == 0 {
+		return fmt.Errorf("at least one element is required")
+	}
+
+	if len(a.Elements) > MaxActionsElements {
+		return fmt.Errorf("has %d elements, the limit is %d", len(a.Elements), MaxActionsElements)
+	}
+
+	for i, element := range a.Elements {
+		if err := element.Validate(); err != nil {
+			return fmt.Errorf("element %d (%s): %s", i, element.ElementType(), err)
+		}
+	}
+
+	return nil
+}
+
+func (a ActionsBlock) MarshalJSON() ([]byte, error) {
+	type actions ActionsBlock
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		actions
+	}{a.BlockType(), actions(a)})
+}
+
+// ButtonElement is a button that either opens a URL, or sends an
+// interaction payload to the app when clicked
+type ButtonElement struct {
+	ActionID string      `json:"action_id,omitempty"`
+	Text     *TextObject `json:"text"`
+	Value    string      `json:"value,omitempty"`
+	URL      string      `json:"url,omitempty"`
+	Style    string      `json:"style,omitempty"`
+}
+
+func (ButtonElement) ElementType() string { return "button" }
+
+func (b ButtonElement) Validate() error {
+	if err := validateLength("action_id", b.ActionID, MaxActionIDLength); err != nil {
+		return err
+	}
+
+	if err := b.Text.validate(MaxButtonTextLength, true); err != nil {
+		return err
+	}
+
+	if err := validateLength("value", b.Value, MaxButtonValueLength); err != nil {
+		return err
+	}
+
+	if err := validateLength("url", b.URL, MaxURLLength); err != nil {
+		return err
+	}
+
+	if b.Style != "" && b.Style != ButtonStylePrimary && b.Style != ButtonStyleDanger {
+		return fmt.Errorf("unknown style %q", b.Style)
+	}
+
+	return nil
+}
+
+func (b ButtonElement) MarshalJSON() ([]byte, error) {
+	type button ButtonElement
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		button
+	}{b.ElementType(), button(b)})
+}
+
+// StaticSelectElement is a drop down menu with a fixed list of options
+type StaticSelectElement struct {
+	ActionID      string      `json:"action_id,omitempty"`
+	Placeholder   *TextObject `json:"placeholder"`
+	Options       []*Option   `json:"options"`
+	InitialOption *Option     `json:"initial_option,omitempty"`
+}
+
+func (StaticSelectElement) ElementType() string { return "static_select" }
+
+func (s StaticSelectElement) Validate() error {
+	if err := validateLength("action_id", s.ActionID, MaxActionIDLength); err != nil {
+		return err
+	}
+
+	if err := s.Placeholder.validate(MaxPlaceholderLength, true); err != nil {
+		return fmt.Errorf("placeholder: %s", err)
+	}
+
+	if len(s.Options) == 0 {
+		return fmt.Errorf("at least one option is required")
+	}
+
+	if len(s.Options) > MaxSelectOptions {
+		return fmt.Errorf("has %d options, the limit is %d", len(s.Options), MaxSelectOptions)
+	}
+
+	for i, option := range s.Options {
+		if err := option.validate(); err != nil {
+			return fmt.Errorf("option %d: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (s StaticSelectElement) MarshalJSON() ([]byte, error) {
+	type staticSelect StaticSelectElement
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		staticSelect
+	}{s.ElementType(), staticSelect(s)})
+}
+
+// Option is a single choice in a select menu
+type Option struct {
+	Text  *TextObject `json:"text"`
+	Value string      `json:"value"`
+}
+
+func (o *Option) validate() error {
+	if err := o.Text.validate(MaxOptionTextLength, true); err != nil {
+		return err
+	}
+
+	return validateLength("value", o.Value, MaxOptionValueLength)
+}
@@ -0,0 +1,74 @@
+package slackutil
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBlockMarshalling(t *testing.T) {
+	t.Run("It includes the type of each block and element", func(t *testing.T) {
+		blocks := []Block{
+			HeaderBlock{Text: PlainText("i-0123456789abcdef0")},
+			SectionBlock{
+				Text:      Markdown("*running*"),
+				Accessory: ButtonElement{ActionID: "tags", Text: PlainText("Tags"), Value: "PRODUCTION"},
+			},
+			DividerBlock{},
+			ContextBlock{Elements: []*TextObject{Markdown("searched 1 account")}},
+		}
+
+		b, err := json.Marshal(blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := `[` +
+			`{"type":"header","text":{"type":"plain_text","text":"i-0123456789abcdef0","emoji":true}},` +
+			`{"type":"section","text":{"type":"mrkdwn","text":"*running*"},"accessory":{"type":"button","action_id":"tags","text":{"type":"plain_text","text":"Tags","emoji":true},"value":"PRODUCTION"}},` +
+			`{"type":"divider"},` +
+			`{"type":"context","elements":[{"type":"mrkdwn","text":"searched 1 account"}]}` +
+			`]`
+		if string(b) != expected {
+			t.Errorf("unexpected JSON\n%s", b)
+		}
+	})
+}
+
+func TestValidateBlocks(t *testing.T) {
+	t.Run("It accepts blocks within slack's limits", func(t *testing.T) {
+		blocks := []Block{
+			SectionBlock{Text: Markdown(strings.Repeat("a", MaxSectionTextLength))},
+			ActionsBlock{Elements: []BlockElement{
+				StaticSelectElement{
+					ActionID:    "account",
+					Placeholder: PlainText("Choose an account"),
+					Options:     []*Option{&Option{Text: PlainText("PRODUCTION"), Value: "PRODUCTION"}},
+				},
+			}},
+		}
+
+		if err := ValidateBlocks(blocks); err != nil {
+			t.Error(err)
+		}
+	})
+
+	cases := map[string][]Block{
+		"too many blocks":       make([]Block, MaxBlocksPerMessage+1),
+		"section text too long": []Block{SectionBlock{Text: Markdown(strings.Repeat("a", MaxSectionTextLength+1))}},
+		"empty section":         []Block{SectionBlock{}},
+		"markdown header":       []Block{HeaderBlock{Text: Markdown("*bold*")}},
+		"button text too long": []Block{ActionsBlock{Elements: []BlockElement{
+			ButtonElement{Text: PlainText(strings.Repeat("a", MaxButtonTextLength+1))},
+		}}},
+		"unknown button style": []Block{ActionsBlock{Elements: []BlockElement{
+			ButtonElement{Text: PlainText("Tags"), Style: "sparkly"},
+		}}},
+	}
+
+	for name, blocks := range cases {
+		if err := ValidateBlocks(blocks); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package slackutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultAPIURL = "https://slack.com/api/"
+
+const (
+	// How many times a call is retried after slack tells us we've hit a
+	// rate limit
+	maxRateLimitRetries = 3
+
+	// Used when a rate limited response doesn't say how long to wait
+	defaultRetryAfter = time.Second
+)
+
+// Client calls slack's Web API using a bot token. Unlike MessageResponder it
+// doesn't need a response_url, so it can post to any channel the bot is in,
+// at any time
+type Client struct {
+	token  string
+	apiURL string
+}
+
+func NewClient(token string) *Client {
+	return &Client{token: token, apiURL: DefaultAPIURL}
+}
+
+// APIError is returned when slack responds with `"ok": false`
+// https://api.slack.com/web#evaluating_responses
+type APIError struct {
+	Method string
+
+	// e.g. channel_not_found or not_in_channel
+	Code string
+
+	// More detail about the error, e.g. which block was invalid
+	Messages []string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Messages) > 0 {
+		return fmt.Sprintf("%s: %s (%s)", e.Method, e.Code, strings.Join(e.Messages, ", "))
+	}
+
+	return fmt.Sprintf("%s: %s", e.Method, e.Code)
+}
+
+// IsAPIError reports whether err is an APIError with one of the given codes
+func IsAPIError(err error, codes ...string) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	for _, code := range codes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RateLimitedError is returned when a call is still rate limited after
+// being retried
+// https://api.slack.com/docs/rate-limits
+type RateLimitedError struct {
+	Method     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%s: rate limited, retry after %s", e.Method, e.RetryAfter)
+}
+
+// Message is a message to post to a channel
+type Message struct {
+	Channel string `json:"channel"`
+
+	// Set to reply in a thread
+	ThreadTS string `json:"thread_ts,omitempty"`
+
+	// When the message has blocks, Text is only used in notifications
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+// PostedMessage identifies a message that was posted, so it can be updated
+type PostedMessage struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// PostMessage posts a message to a channel
+// https://api.slack.com/methods/chat.postMessage
+func (c *Client) PostMessage(ctx context.Context, msg Message) (*PostedMessage, error) {
+	posted := &PostedMessage{}
+	if err := c.call(ctx, "chat.postMessage", msg, posted); err != nil {
+		return nil, err
+	}
+
+	return posted, nil
+}
+
+// MessageUpdate replaces the contents of a message the bot posted
+type MessageUpdate struct {
+	Channel string  `json:"channel"`
+	TS      string  `json:"ts"`
+	Text    string  `json:"text"`
+	Blocks  []Block `json:"blocks"`
+}
+
+// UpdateMessage changes a message the bot posted
+// https://api.slack.com/methods/chat.update
+func (c *Client) UpdateMessage(ctx context.Context, update MessageUpdate) error {
+	return c.call(ctx, "chat.update", update, nil)
+}
+
+// DeleteMessage deletes a message the bot posted
+// https://api.slack.com/methods/chat.delete
+func (c *Client) DeleteMessage(ctx context.Context, channel, ts string) error {
+	return c.call(ctx, "chat.delete", PostedMessage{Channel: channel, TS: ts}, nil)
+}
+
+// EphemeralMessage is a message only one user in a channel can see
+type EphemeralMessage struct {
+	Channel  string  `json:"channel"`
+	User     string  `json:"user"`
+	ThreadTS string  `json:"thread_ts,omitempty"`
+	Text     string  `json:"text"`
+	Blocks   []Block `json:"blocks,omitempty"`
+}
+
+// PostEphemeral shows a message to a single user in a channel
+// https://api.slack.com/methods/chat.postEphemeral
+func (c *Client) PostEphemeral(ctx context.Context, msg EphemeralMessage) error {
+	return c.call(ctx, "chat.postEphemeral", msg, nil)
+}
+
+// View is a modal
+// https://api.slack.com/reference/surfaces/views
+type View struct {
+	Type       string      `json:"type"`
+	Title      *TextObject `json:"title"`
+	Close      *TextObject `json:"close,omitempty"`
+	Submit     *TextObject `json:"submit,omitempty"`
+	Blocks     []Block     `json:"blocks"`
+	CallbackID string      `json:"callback_id,omitempty"`
+
+	// Passed back to the app when the modal is submitted
+	PrivateMetadata string `json:"private_metadata,omitempty"`
+}
+
+const ViewTypeModal = "modal"
+
+// OpenView opens a modal. The trigger ID comes from the slash command or
+// interaction the modal is in response to, and expires after 3 seconds
+// https://api.slack.com/methods/views.open
+func (c *Client) OpenView(ctx context.Context, triggerID string, view View) error {
+	return c.call(ctx, "views.open", struct {
+		TriggerID string `json:"trigger_id"`
+		View      View   `json:"view"`
+	}{triggerID, view}, nil)
+}
+
+// FileUpload is a text file to share in one or more channels, e.g. output
+// that's too long for a message
+type FileUpload struct {
+	Channels       []string
+	ThreadTS       string
+	Filename       string
+	Title          string
+	Filetype       string
+	InitialComment string
+	Content        string
+}
+
+// UploadFile uploads a text file and shares it in the given channels
+// https://api.slack.com/methods/files.upload
+func (c *Client) UploadFile(ctx context.Context, file FileUpload) error {
+	form := url.Values{}
+	form.Set("channels", strings.Join(file.Channels, ","))
+	form.Set("content", file.Content)
+
+	optional := map[string]string{
+		"thread_ts":       file.ThreadTS,
+		"filename":        file.Filename,
+		"title":           file.Title,
+		"filetype":        file.Filetype,
+		"initial_comment": file.InitialComment,
+	}
+	for key, value := range optional {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+
+	// files.upload doesn't accept JSON
+	return c.do(ctx, "files.upload", "application/x-www-form-urlencoded", []byte(form.Encode()), nil)
+}
+
+// Unfurl is the preview shown for a link
+type Unfurl struct {
+	Blocks []Block `json:"blocks"`
+}
+
+// UnfurlRequest adds previews to the links in a message. Links shared in
+// the message composer are identified by UnfurlID and Source, rather than
+// Channel and TS
+type UnfurlRequest struct {
+	Channel  string `json:"channel,omitempty"`
+	TS       string `json:"ts,omitempty"`
+	UnfurlID string `json:"unfurl_id,omitempty"`
+	Source   string `json:"source,omitempty"`
+
+	// Previews keyed by the URL they're for
+	Unfurls map[string]Unfurl `json:"unfurls"`
+}
+
+// Unfurl adds previews to links in a message
+// https://api.slack.com/methods/chat.unfurl
+func (c *Client) Unfurl(ctx context.Context, req UnfurlRequest) error {
+	return c.call(ctx, "chat.unfurl", req, nil)
+}
+
+// UserGroupMembers lists the IDs of the users in a user group
+// https://api.slack.com/methods/usergroups.users.list
+func (c *Client) UserGroupMembers(ctx context.Context, userGroupID string) ([]string, error) {
+	form := url.Values{}
+	form.Set("usergroup", userGroupID)
+
+	var members struct {
+		Users []string `json:"users"`
+	}
+
+	// Like most read methods, usergroups.users.list doesn't accept JSON
+	if err := c.do(ctx, "usergroups.users.list", "application/x-www-form-urlencoded", []byte(form.Encode()), &members); err != nil {
+		return nil, err
+	}
+
+	return members.Users, nil
+}
+
+// call sends args to a Web API method as JSON. If result isn't nil, the
+// response is decoded into it
+func (c *Client) call(ctx context.Context, method string, args interface{}, result interface{}) error {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, method, "application/json; charset=utf-8", b, result)
+}
+
+// do sends a request to a Web API method, retrying if we're rate limited
+func (c *Client) do(ctx context.Context, method, contentType string, body []byte, result interface{}) error {
+	for attempt := 0; ; attempt++ {
+		r, err := http.NewRequest("POST", c.apiURL+method, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		r = r.WithContext(ctx)
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Authorization", "Bearer "+c.token)
+
+		resp, err := slackClient.Do(r)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+			if attempt >= maxRateLimitRetries {
+				return &RateLimitedError{Method: method, RetryAfter: retryAfter}
+			}
+
+			select {
+			case <-time.After(retryAfter):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return decodeAPIResponse(method, resp, result)
+	}
+}
+
+func decodeAPIResponse(method string, resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("%s: unexpected status %s", method, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		OK               bool   `json:"ok"`
+		Error            string `json:"error"`
+		ResponseMetadata struct {
+			Messages []string `json:"messages"`
+		} `json:"response_metadata"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+
+	if !envelope.OK {
+		return &APIError{Method: method, Code: envelope.Error, Messages: envelope.ResponseMetadata.Messages}
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+// parseRetryAfter reads the number of seconds slack wants us to wait
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return defaultRetryAfter
+	}
+
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,148 @@
+package slackutil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	var (
+		received    map[string]interface{}
+		receivedRaw *http.Request
+		rateLimited int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-token" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+
+		receivedRaw = r
+		received = nil
+		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			r.ParseForm()
+		} else {
+			json.NewDecoder(r.Body).Decode(&received)
+		}
+
+		switch {
+		case r.URL.Path == "/chat.update" && rateLimited > 0:
+			rateLimited--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case received["channel"] == "C_ARCHIVED":
+			w.Write([]byte(`{"ok": false, "error": "invalid_blocks", "response_metadata": {"messages": ["[ERROR] must be more than 0 characters [json-pointer:/blocks/0/text]"]}}`))
+		case r.URL.Path == "/usergroups.users.list" && r.PostForm.Get("usergroup") == "S0614TZR7":
+			w.Write([]byte(`{"ok": true, "users": ["U060R4BJ4", "W123A4BC5"]}`))
+		case r.URL.Path == "/chat.postMessage":
+			w.Write([]byte(`{"ok": true, "channel": "C0LAN2Q65", "ts": "1548261232.000300"}`))
+		default:
+			w.Write([]byte(`{"ok": true}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{token: "xoxb-token", apiURL: server.URL + "/"}
+
+	t.Run("It posts a message and returns where it was posted", func(t *testing.T) {
+		posted, err := client.PostMessage(context.Background(), Message{
+			Channel:  "C0LAN2Q65",
+			ThreadTS: "1548261231.000200",
+			Text:     "hello",
+			Blocks:   []Block{DividerBlock{}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if received["thread_ts"] != "1548261231.000200" || received["text"] != "hello" {
+			t.Errorf("unexpected message %#v", received)
+		}
+
+		if posted.Channel != "C0LAN2Q65" || posted.TS != "1548261232.000300" {
+			t.Errorf("unexpected posted message %#v", posted)
+		}
+	})
+
+	t.Run("It returns slack's error as an APIError", func(t *testing.T) {
+		_, err := client.PostMessage(context.Background(), Message{Channel: "C_ARCHIVED", Text: "hello"})
+
+		if !IsAPIError(err, "invalid_blocks") {
+			t.Fatalf("unexpected error %#v", err)
+		}
+
+		if err.(*APIError).Messages[0] != "[ERROR] must be more than 0 characters [json-pointer:/blocks/0/text]" {
+			t.Errorf("unexpected messages %#v", err.(*APIError).Messages)
+		}
+	})
+
+	t.Run("It retries calls that are rate limited", func(t *testing.T) {
+		rateLimited = 2
+
+		err := client.UpdateMessage(context.Background(), MessageUpdate{Channel: "C0LAN2Q65", TS: "1548261232.000300", Text: "updated"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rateLimited != 0 {
+			t.Errorf("expected every rate limited response to be retried, %d left", rateLimited)
+		}
+	})
+
+	t.Run("It gives up if it's still rate limited", func(t *testing.T) {
+		rateLimited = maxRateLimitRetries + 1
+
+		err := client.UpdateMessage(context.Background(), MessageUpdate{Channel: "C0LAN2Q65", TS: "1548261232.000300", Text: "updated"})
+		if _, ok := err.(*RateLimitedError); !ok {
+			t.Errorf("unexpected error %#v", err)
+		}
+
+		rateLimited = 0
+	})
+
+	t.Run("It opens views with the trigger ID", func(t *testing.T) {
+		err := client.OpenView(context.Background(), "398738663015.47445629121", View{
+			Type:   ViewTypeModal,
+			Title:  PlainText("Results"),
+			Blocks: []Block{DividerBlock{}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if received["trigger_id"] != "398738663015.47445629121" || received["view"].(map[string]interface{})["type"] != "modal" {
+			t.Errorf("unexpected request %#v", received)
+		}
+	})
+
+	t.Run("It uploads files as a form", func(t *testing.T) {
+		err := client.UploadFile(context.Background(), FileUpload{
+			Channels: []string{"C0LAN2Q65"},
+			ThreadTS: "1548261231.000200",
+			Filename: "console.txt",
+			Content:  "Cloud-init finished",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := receivedRaw.PostForm
+		if form.Get("channels") != "C0LAN2Q65" || form.Get("content") != "Cloud-init finished" || form.Get("filename") != "console.txt" || form.Get("title") != "" {
+			t.Errorf("unexpected form %#v", form)
+		}
+	})
+
+	t.Run("It lists the members of a user group", func(t *testing.T) {
+		members, err := client.UserGroupMembers(context.Background(), "S0614TZR7")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(members) != 2 || members[0] != "U060R4BJ4" || members[1] != "W123A4BC5" {
+			t.Errorf("unexpected members %#v", members)
+		}
+	})
+}
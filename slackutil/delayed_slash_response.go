@@ -1,27 +1,31 @@
 package slackutil
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"time"
 
 	bugsnag "github.com/bugsnag/bugsnag-go"
 )
 
-var slackClient = http.Client{}
+var slackClient = http.Client{Timeout: 10 * time.Second}
 var forceShowSlashCommandInChannelResponse = Response{ResponseType: ResponseInChannel}
 
 type DelayedSlashResponse struct {
-	// A mesage to send the user while we're preparing a response to
+	// A mesage to send the user while we're preparing a response to. It's
+	// replaced by the response once the handler sends one
 	PendingResponse Response
 
 	// Should the command be visible to all other users in the channel?
 	// Doing this changes how we respond to the slash command webhook
 	ShowSlashCommandInChannel bool
 
+	// If set, the pending response is posted to the channel with the bot
+	// token, and updated in place with the response. Otherwise the pending
+	// response is only shown to the user who ran the command. The client is
+	// also used if the response URL has expired
+	Client *Client
+
 	Handler func(context.Context, SlashCommandRequest, MessageResponder)
 }
 
@@ -43,16 +47,32 @@ func (d DelayedSlashResponse) Run(w http.ResponseWriter, command SlashCommandReq
 }
 
 func (d DelayedSlashResponse) runHandler(command SlashCommandRequest) {
-	ctx := context.Background()
+	responder := newMessageResponder(command.ResponseURL)
+	if d.Client != nil {
+		responder = responder.withClient(d.Client, command.ChannelID, command.UserID)
+
+		// The pending message can only be updated in place if it's posted
+		// with the bot token, which makes it public
+		responder.pendingInChannel = d.ShowSlashCommandInChannel
+	}
 
-	responder := MessageResponder{command}
+	runWithPendingResponse(responder, d.PendingResponse, func(ctx context.Context) {
+		d.Handler(ctx, command, responder)
+	})
+}
+
+// runWithPendingResponse runs the handler, and sends the user the pending
+// response if the handler takes a while. An empty pending response is not
+// sent
+func runWithPendingResponse(responder MessageResponder, pending Response, handler func(context.Context)) {
+	ctx := context.Background()
 
 	done := make(chan struct{})
 
 	// Not using a waitgroup here as we don't really care about cleaning up this goroutine
 	go func() {
 		defer bugsnag.AutoNotify(ctx)
-		d.Handler(ctx, command, responder)
+		handler(ctx)
 		close(done)
 	}()
 
@@ -63,57 +83,9 @@ func (d DelayedSlashResponse) runHandler(command SlashCommandRequest) {
 		case <-done:
 			return
 		case <-notifyUserTimeout:
-			responder.EphemeralResponse(d.PendingResponse)
+			if pending.Text != "" || len(pending.Blocks) > 0 {
+				responder.showPending(pending)
+			}
 		}
 	}
 }
-
-type MessageResponder struct {
-	command SlashCommandRequest
-}
-
-func (m MessageResponder) EphemeralResponse(resp Response) {
-	resp.ResponseType = ResponseEphemeral
-
-	b, err := json.Marshal(&resp)
-	if err != nil {
-		panic(err)
-	}
-
-	r, err := http.NewRequest("POST", m.command.ResponseURL, bytes.NewReader(b))
-	if err != nil {
-		panic(err)
-	}
-
-	apiResp, err := slackClient.Do(r)
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Println(apiResp)
-}
-
-func (m MessageResponder) PublicResponse(resp Response) {
-	resp.ResponseType = ResponseInChannel
-
-	b, err := json.Marshal(&resp)
-	if err != nil {
-		panic(err)
-	}
-
-	r, err := http.NewRequest("POST", m.command.ResponseURL, bytes.NewReader(b))
-	if err != nil {
-		panic(err)
-	}
-
-	apiResp, err := slackClient.Do(r)
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Println(apiResp)
-}
-
-type SlashCommandResponder interface {
-	PublicResponse(Response)
-}
@@ -0,0 +1,251 @@
+package slackutil
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+)
+
+// Types of request sent to the events request URL
+// https://api.slack.com/apis/connections/events-api
+const (
+	EventsURLVerification = "url_verification"
+	EventsCallback        = "event_callback"
+)
+
+// Types of event
+// https://api.slack.com/events
+const (
+	EventAppMention = "app_mention"
+	EventLinkShared = "link_shared"
+	EventMessage    = "message"
+)
+
+const (
+	SlackRetryNumHeader    = "X-Slack-Retry-Num"
+	SlackRetryReasonHeader = "X-Slack-Retry-Reason"
+
+	// Slack retries an event up to three times over roughly five minutes, so
+	// we remember events for a bit longer than that
+	eventDeduplicationWindow = 10 * time.Minute
+)
+
+// EventEnvelope wraps every request slack sends to the events request URL
+type EventEnvelope struct {
+	Type     string `json:"type"`
+	TeamID   string `json:"team_id"`
+	APIAppID string `json:"api_app_id"`
+
+	// Only set for url_verification requests
+	Challenge string `json:"challenge"`
+
+	// Uniquely identifies the event, so retries can be spotted
+	EventID   string `json:"event_id"`
+	EventTime int64  `json:"event_time"`
+
+	// The users the event was delivered to the app on behalf of, including
+	// the bot user
+	Authorizations []EventAuthorization `json:"authorizations"`
+
+	Event Event `json:"event"`
+}
+
+type EventAuthorization struct {
+	TeamID string `json:"team_id"`
+	UserID string `json:"user_id"`
+	IsBot  bool   `json:"is_bot"`
+}
+
+// BotUserID is the app's bot user, if the event was delivered to it
+func (e EventEnvelope) BotUserID() string {
+	for _, authorization := range e.Authorizations {
+		if authorization.IsBot {
+			return authorization.UserID
+		}
+	}
+
+	return ""
+}
+
+// Event holds the fields most message related events have in common. The
+// full event is kept in Raw, for handlers that need anything else
+type Event struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	Channel  string `json:"channel"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+	EventTS  string `json:"event_ts"`
+
+	// Set for link_shared events
+	MessageTS string       `json:"message_ts"`
+	Links     []SharedLink `json:"links"`
+	UnfurlID  string       `json:"unfurl_id"`
+	Source    string       `json:"source"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	type event Event
+	if err := json.Unmarshal(b, (*event)(e)); err != nil {
+		return err
+	}
+
+	e.Raw = append(json.RawMessage{}, b...)
+	return nil
+}
+
+// SharedLink is a link in a message, from a domain the app unfurls
+type SharedLink struct {
+	Domain string `json:"domain"`
+	URL    string `json:"url"`
+}
+
+// ReplyThreadTS is the timestamp to use to reply to the event in a thread.
+// If the event was already in a thread we reply in that thread, otherwise
+// we start a new one
+func (e Event) ReplyThreadTS() string {
+	if e.ThreadTS != "" {
+		return e.ThreadTS
+	}
+
+	return e.TS
+}
+
+func ParseEventEnvelope(r *http.Request) (*EventEnvelope, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &EventEnvelope{}
+	if err := json.Unmarshal(body, envelope); err != nil {
+		return nil, err
+	}
+
+	return envelope, nil
+}
+
+// EventHandler responds to a single event
+type EventHandler func(context.Context, EventEnvelope)
+
+// EventRouter sends each event to the handler registered for its type. Slack
+// expects events to be acknowledged within 3 seconds, and retries them if
+// they aren't, so handlers are always run in the background
+type EventRouter struct {
+	mu       sync.RWMutex
+	handlers map[string]EventHandler
+
+	seen *recentEvents
+}
+
+func NewEventRouter() *EventRouter {
+	return &EventRouter{
+		handlers: map[string]EventHandler{},
+		seen:     newRecentEvents(eventDeduplicationWindow),
+	}
+}
+
+// HandleEvent registers the handler for a type of event
+func (e *EventRouter) HandleEvent(eventType string, handler EventHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.handlers[eventType] = handler
+}
+
+func (e *EventRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	envelope, err := ParseEventEnvelope(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("could not parse payload"))
+		return
+	}
+
+	switch envelope.Type {
+	case EventsURLVerification:
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"challenge": envelope.Challenge})
+		return
+
+	case EventsCallback:
+		// Every event is remembered, but only retries are checked, so that
+		// a first delivery is never dropped
+		seen := e.seen.Add(envelope.EventID, getNowTime())
+		if seen && r.Header.Get(SlackRetryNumHeader) != "" {
+			log.Printf(
+				"ignoring retry %s of event %s (%s)",
+				r.Header.Get(SlackRetryNumHeader),
+				envelope.EventID,
+				r.Header.Get(SlackRetryReasonHeader),
+			)
+			break
+		}
+
+		e.mu.RLock()
+		handler, ok := e.handlers[envelope.Event.Type]
+		e.mu.RUnlock()
+
+		if !ok {
+			log.Printf("no handler for event %q", envelope.Event.Type)
+			break
+		}
+
+		go func() {
+			ctx := context.Background()
+			defer bugsnag.AutoNotify(ctx)
+
+			handler(ctx, *envelope)
+		}()
+
+	default:
+		log.Printf("unknown events API request %q", envelope.Type)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// recentEvents remembers the IDs of events we've received recently
+type recentEvents struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+func newRecentEvents(window time.Duration) *recentEvents {
+	return &recentEvents{window: window, seen: map[string]time.Time{}}
+}
+
+// Add records an event, and returns whether it had already been seen within
+// the window. Events older than the window are forgotten
+func (r *recentEvents) Add(eventID string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, at := range r.seen {
+		if now.Sub(at) > r.window {
+			delete(r.seen, id)
+		}
+	}
+
+	if eventID == "" {
+		return false
+	}
+
+	_, seen := r.seen[eventID]
+	if !seen {
+		r.seen[eventID] = now
+	}
+
+	return seen
+}
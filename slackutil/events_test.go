@@ -0,0 +1,130 @@
+package slackutil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const appMentionPayload = `{
+	"type": "event_callback",
+	"team_id": "T1DC2JH3J",
+	"api_app_id": "A0123ABCD",
+	"event_id": "Ev0123ABCD",
+	"event_time": 1548261231,
+	"event": {
+		"type": "app_mention",
+		"user": "U2CERLKJA",
+		"text": "<@U0LAN0Z89> i-0123456789abcdef0",
+		"ts": "1548261231.000200",
+		"channel": "C0LAN2Q65",
+		"event_ts": "1548261231.000200"
+	}
+}`
+
+func makeEventRequest(payload string) *http.Request {
+	r := httptest.NewRequest("POST", "/slack/events", strings.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestEventRouter(t *testing.T) {
+	t.Run("It answers the url_verification challenge", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		NewEventRouter().ServeHTTP(w, makeEventRequest(`{"type": "url_verification", "token": "x", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`))
+
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if w.Code != http.StatusOK || body["challenge"] != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+			t.Errorf("unexpected response %d %#v", w.Code, body)
+		}
+	})
+
+	t.Run("It sends events to the handler registered for their type", func(t *testing.T) {
+		called := make(chan EventEnvelope, 1)
+
+		router := NewEventRouter()
+		router.HandleEvent(EventAppMention, func(ctx context.Context, envelope EventEnvelope) {
+			called <- envelope
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, makeEventRequest(appMentionPayload))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status code %d", w.Code)
+		}
+
+		select {
+		case envelope := <-called:
+			if envelope.EventID != "Ev0123ABCD" || envelope.Event.Channel != "C0LAN2Q65" || envelope.Event.ReplyThreadTS() != "1548261231.000200" {
+				t.Errorf("unexpected envelope %#v", envelope)
+			}
+			if len(envelope.Event.Raw) == 0 {
+				t.Error("expected the raw event to be kept")
+			}
+		case <-time.After(time.Second):
+			t.Error("handler was not called")
+		}
+	})
+
+	t.Run("It ignores retries of events it has already received", func(t *testing.T) {
+		called := make(chan EventEnvelope, 3)
+
+		router := NewEventRouter()
+		router.HandleEvent(EventAppMention, func(ctx context.Context, envelope EventEnvelope) {
+			called <- envelope
+		})
+
+		router.ServeHTTP(httptest.NewRecorder(), makeEventRequest(appMentionPayload))
+
+		retry := makeEventRequest(appMentionPayload)
+		retry.Header.Set(SlackRetryNumHeader, "1")
+		retry.Header.Set(SlackRetryReasonHeader, "http_timeout")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, retry)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected retries to be acknowledged, got %d", w.Code)
+		}
+
+		<-called
+		select {
+		case <-called:
+			t.Error("expected the retry to be ignored")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("It rejects payloads it can't parse", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		NewEventRouter().ServeHTTP(w, makeEventRequest("{"))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("unexpected status code %d", w.Code)
+		}
+	})
+}
+
+func TestRecentEvents(t *testing.T) {
+	now := time.Now()
+	recent := newRecentEvents(time.Minute)
+
+	if recent.Add("Ev1", now) {
+		t.Error("did not expect a new event to have been seen")
+	}
+	if !recent.Add("Ev1", now.Add(30*time.Second)) {
+		t.Error("expected the event to have been seen")
+	}
+	if recent.Add("Ev1", now.Add(2*time.Minute)) {
+		t.Error("expected the event to have been forgotten")
+	}
+}
@@ -0,0 +1,219 @@
+package slackutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Types of interaction payload
+// https://api.slack.com/reference/interaction-payloads
+const (
+	InteractionBlockActions       = "block_actions"
+	InteractionInteractiveMessage = "interactive_message"
+	InteractionMessageAction      = "message_action"
+	InteractionShortcut           = "shortcut"
+)
+
+// InteractionPayload is sent when a user clicks a button, chooses an option
+// from a menu, or uses a shortcut
+type InteractionPayload struct {
+	Type string `json:"type"`
+
+	Team    InteractionTeam    `json:"team"`
+	User    InteractionUser    `json:"user"`
+	Channel InteractionChannel `json:"channel"`
+
+	// Where the interaction happened, e.g. which message a button was in
+	Container InteractionContainer `json:"container"`
+
+	// The message the user interacted with, if there was one
+	Message *InteractionMessage `json:"message,omitempty"`
+
+	// Identifies the shortcut that was used, or the attachment that was
+	// interacted with in legacy interactive messages
+	CallbackID string `json:"callback_id"`
+
+	Actions []Action `json:"actions"`
+
+	// A URL that you can use to respond to the interaction
+	ResponseURL string `json:"response_url"`
+
+	// Lets you open a modal in response to the interaction
+	TriggerID string `json:"trigger_id"`
+}
+
+type InteractionTeam struct {
+	ID     string `json:"id"`
+	Domain string `json:"domain"`
+}
+
+type InteractionUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	TeamID   string `json:"team_id"`
+}
+
+type InteractionChannel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type InteractionContainer struct {
+	Type        string `json:"type"`
+	MessageTS   string `json:"message_ts"`
+	ThreadTS    string `json:"thread_ts"`
+	ChannelID   string `json:"channel_id"`
+	IsEphemeral bool   `json:"is_ephemeral"`
+}
+
+type InteractionMessage struct {
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+	Text     string `json:"text"`
+
+	// The message's blocks are left as JSON, so that they can be sent back
+	// to slack untouched when updating the message
+	Blocks []json.RawMessage `json:"blocks"`
+}
+
+// Action describes which element a user interacted with
+type Action struct {
+	Type     string `json:"type"`
+	ActionID string `json:"action_id"`
+	BlockID  string `json:"block_id"`
+	Value    string `json:"value"`
+	ActionTS string `json:"action_ts"`
+
+	// Set when the action was a select menu
+	SelectedOption *Option `json:"selected_option,omitempty"`
+
+	// Legacy interactive messages identify actions by name rather than
+	// action_id
+	Name string `json:"name,omitempty"`
+}
+
+// ParseInteractionPayload reads the payload slack sends to the interactivity
+// request URL. Unlike slash commands, the payload is JSON stored in the
+// `payload` form field
+func ParseInteractionPayload(r *http.Request) (*InteractionPayload, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	raw := r.PostForm.Get("payload")
+	if raw == "" {
+		return nil, errors.New("missing payload")
+	}
+
+	payload := &InteractionPayload{}
+	if err := json.Unmarshal([]byte(raw), payload); err != nil {
+		return nil, err
+	}
+
+	for i, action := range payload.Actions {
+		if action.ActionID == "" {
+			payload.Actions[i].ActionID = action.Name
+		}
+	}
+
+	return payload, nil
+}
+
+// ActionHandler responds to a single action in an interaction payload
+type ActionHandler func(context.Context, InteractionPayload, Action, MessageResponder)
+
+// DelayedActionResponse runs an action handler in the background, like
+// DelayedSlashResponse does for slash commands
+type DelayedActionResponse struct {
+	// A message to send the user while we're preparing a response to the
+	// action. Nothing is sent if this is empty
+	PendingResponse Response
+
+	Handler ActionHandler
+}
+
+func (d DelayedActionResponse) run(payload InteractionPayload, action Action) {
+	responder := newMessageResponder(payload.ResponseURL)
+
+	runWithPendingResponse(responder, d.PendingResponse, func(ctx context.Context) {
+		d.Handler(ctx, payload, action, responder)
+	})
+}
+
+// InteractionRouter sends each action in an interaction payload to the
+// handler registered for its action_id, and shortcuts to the handler
+// registered for their callback_id. Slack expects interactions to be
+// acknowledged within 3 seconds, so handlers are always run in the
+// background
+type InteractionRouter struct {
+	mu        sync.RWMutex
+	handlers  map[string]DelayedActionResponse
+	shortcuts map[string]DelayedActionResponse
+}
+
+func NewInteractionRouter() *InteractionRouter {
+	return &InteractionRouter{
+		handlers:  map[string]DelayedActionResponse{},
+		shortcuts: map[string]DelayedActionResponse{},
+	}
+}
+
+// HandleAction registers the handler for an action_id
+func (i *InteractionRouter) HandleAction(actionID string, handler DelayedActionResponse) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.handlers[actionID] = handler
+}
+
+// HandleShortcut registers the handler for a global or message shortcut's
+// callback_id. Shortcuts don't have actions, so the handler is passed an
+// empty Action
+func (i *InteractionRouter) HandleShortcut(callbackID string, handler DelayedActionResponse) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.shortcuts[callbackID] = handler
+}
+
+func (i *InteractionRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := ParseInteractionPayload(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("could not parse payload"))
+		return
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if payload.Type == InteractionMessageAction || payload.Type == InteractionShortcut {
+		if handler, ok := i.shortcuts[payload.CallbackID]; ok {
+			go handler.run(*payload, Action{})
+		} else {
+			log.Printf("no handler for shortcut %q", payload.CallbackID)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	for _, action := range payload.Actions {
+		handler, ok := i.handlers[action.ActionID]
+		if !ok {
+			log.Printf("no handler for action %q", action.ActionID)
+			continue
+		}
+
+		go handler.run(*payload, action)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,145 @@
+package slackutil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const blockActionsPayload = `{
+	"type": "block_actions",
+	"team": {"id": "T1DC2JH3J", "domain": "testteamnow"},
+	"user": {"id": "U2CERLKJA", "username": "roadrunner"},
+	"channel": {"id": "G8PSS9T3V", "name": "foobar"},
+	"container": {"type": "message", "message_ts": "1548261231.000200", "channel_id": "G8PSS9T3V"},
+	"message": {"type": "message", "ts": "1548261231.000200", "blocks": [{"type": "divider"}]},
+	"response_url": "https://hooks.slack.com/actions/T1DC2JH3J/397700885554/96rGlfmibIGlgcZRskXaIFfN",
+	"trigger_id": "398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c",
+	"actions": [
+		{"type": "button", "action_id": "ec2.instance.tags", "block_id": "ec2.instance", "value": "PRODUCTION", "action_ts": "1548426417.840180"}
+	]
+}`
+
+func makeInteractionRequest(payload string) *http.Request {
+	form := url.Values{}
+	form.Set("payload", payload)
+
+	r := httptest.NewRequest("POST", "/slack/interactions", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestParseInteractionPayload(t *testing.T) {
+	t.Run("It parses the JSON in the payload field", func(t *testing.T) {
+		payload, err := ParseInteractionPayload(makeInteractionRequest(blockActionsPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payload.Type != InteractionBlockActions || payload.User.ID != "U2CERLKJA" || payload.Channel.ID != "G8PSS9T3V" {
+			t.Errorf("unexpected payload %#v", payload)
+		}
+
+		if len(payload.Actions) != 1 || payload.Actions[0].ActionID != "ec2.instance.tags" || payload.Actions[0].Value != "PRODUCTION" {
+			t.Errorf("unexpected actions %#v", payload.Actions)
+		}
+
+		if len(payload.Message.Blocks) != 1 {
+			t.Errorf("expected the message's blocks to be kept, got %d", len(payload.Message.Blocks))
+		}
+	})
+
+	t.Run("It uses the action name for legacy interactive messages", func(t *testing.T) {
+		payload, err := ParseInteractionPayload(makeInteractionRequest(`{"type": "interactive_message", "callback_id": "results", "actions": [{"name": "tags", "type": "button", "value": "x"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payload.Actions[0].ActionID != "tags" {
+			t.Errorf("unexpected action ID %q", payload.Actions[0].ActionID)
+		}
+	})
+
+	t.Run("It rejects requests without a payload", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/slack/interactions", strings.NewReader("foo=bar"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		if _, err := ParseInteractionPayload(r); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestInteractionRouter(t *testing.T) {
+	t.Run("It sends each action to the handler registered for its action_id", func(t *testing.T) {
+		called := make(chan Action, 1)
+
+		router := NewInteractionRouter()
+		router.HandleAction("ec2.instance.tags", DelayedActionResponse{
+			Handler: func(ctx context.Context, payload InteractionPayload, action Action, resp MessageResponder) {
+				called <- action
+			},
+		})
+		router.HandleAction("ec2.instance.security_groups", DelayedActionResponse{
+			Handler: func(ctx context.Context, payload InteractionPayload, action Action, resp MessageResponder) {
+				t.Error("did not expect the security groups handler to be called")
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, makeInteractionRequest(blockActionsPayload))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status code %d", w.Code)
+		}
+
+		select {
+		case action := <-called:
+			if action.BlockID != "ec2.instance" {
+				t.Errorf("unexpected action %#v", action)
+			}
+		case <-time.After(time.Second):
+			t.Error("handler was not called")
+		}
+	})
+
+	t.Run("It sends shortcuts to the handler registered for their callback_id", func(t *testing.T) {
+		called := make(chan InteractionPayload, 1)
+
+		router := NewInteractionRouter()
+		router.HandleShortcut("look_up_infrastructure", DelayedActionResponse{
+			Handler: func(ctx context.Context, payload InteractionPayload, action Action, resp MessageResponder) {
+				called <- payload
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, makeInteractionRequest(`{"type": "message_action", "callback_id": "look_up_infrastructure", "channel": {"id": "C0LAN2Q65"}, "message": {"ts": "1548261231.000200", "text": "i-0123456789abcdef0 is down"}}`))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status code %d", w.Code)
+		}
+
+		select {
+		case payload := <-called:
+			if payload.Message.Text != "i-0123456789abcdef0 is down" {
+				t.Errorf("unexpected payload %#v", payload)
+			}
+		case <-time.After(time.Second):
+			t.Error("handler was not called")
+		}
+	})
+
+	t.Run("It rejects payloads it can't parse", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		NewInteractionRouter().ServeHTTP(w, makeInteractionRequest("{"))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("unexpected status code %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,420 @@
+package slackutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+)
+
+const (
+	// A response_url can only be used 5 times, and we need up to three of
+	// those for the pending message, removing it, and the response
+	maxResponseURLProgressUpdates = 2
+
+	// Progress updates more often than this are dropped, so that we don't
+	// hit slack's rate limits
+	minProgressInterval = time.Second
+
+	// How many times we try to send a message to a response URL, if slack
+	// is having problems
+	maxDeliveryAttempts = 4
+
+	// Progress updates are given up on quickly, rather than retried, as
+	// there'll be another one along soon
+	progressTimeout = 2 * time.Second
+)
+
+// How long we wait before retrying a message the first time. The wait is
+// doubled for each retry after that. Overridden in tests
+var deliveryBackoff = 250 * time.Millisecond
+
+var (
+	// A response URL can only be used for 30 minutes
+	ErrResponseURLExpired = errors.New("response_url: expired_url")
+
+	// A response URL can only be used 5 times
+	ErrResponseURLUsed = errors.New("response_url: used_url")
+)
+
+// MessageResponder sends messages using the response URL slack gives us with
+// slash commands and interactions. If a pending message was shown while the
+// response was being prepared, the response replaces it.
+//
+// Failures are reported to bugsnag by the responder, so callers only need to
+// check the error if they want to do something else instead
+type MessageResponder struct {
+	responseURL string
+
+	// Used to post and update the pending message if pendingInChannel is
+	// set, and to respond if the response URL has expired. Nil if we don't
+	// have a bot token
+	client           *Client
+	channel          string
+	user             string
+	pendingInChannel bool
+
+	state *responderState
+}
+
+// responderState is shared by every copy of a MessageResponder, so that it
+// knows which messages it's already sent
+type responderState struct {
+	mu sync.Mutex
+
+	// Set once the handler has responded. After that, pending messages and
+	// progress updates aren't sent
+	answered bool
+
+	// Closed once the handler has responded, which stops progress updates
+	// being sent
+	done chan struct{}
+
+	// The latest progress update that hasn't been sent yet. Older updates
+	// are dropped, so that a slow slack never holds up a search
+	progress       chan string
+	progressSender sync.Once
+
+	// Set if a pending message was sent using the response URL
+	pendingEphemeral bool
+
+	// Set if a pending message was posted using the bot token
+	pendingMessage *PostedMessage
+
+	lastProgress    time.Time
+	progressUpdates int
+}
+
+func newMessageResponder(responseURL string) MessageResponder {
+	return MessageResponder{
+		responseURL: responseURL,
+		state: &responderState{
+			done:     make(chan struct{}),
+			progress: make(chan string, 1),
+		},
+	}
+}
+
+// answer records that the handler has responded. The caller must hold the
+// state's lock
+func (s *responderState) answer() {
+	if !s.answered {
+		s.answered = true
+		close(s.done)
+	}
+}
+
+// withClient lets the responder use the bot token to post in the channel
+// the user is in
+func (m MessageResponder) withClient(client *Client, channel, user string) MessageResponder {
+	m.client = client
+	m.channel = channel
+	m.user = user
+	return m
+}
+
+func (m MessageResponder) showPending(pending Response) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	if m.state.answered {
+		return
+	}
+
+	if m.client != nil && m.pendingInChannel {
+		posted, err := m.client.PostMessage(context.Background(), Message{
+			Channel: m.channel,
+			Text:    pending.Text,
+			Blocks:  pending.Blocks,
+		})
+		if err == nil {
+			m.state.pendingMessage = posted
+			return
+		}
+
+		// Usually because the bot isn't in the channel, in which case the
+		// response URL still works
+		log.Print(err)
+	}
+
+	pending.ResponseType = ResponseEphemeral
+	if err := m.post(pending); err != nil {
+		m.report(err)
+		return
+	}
+
+	m.state.pendingEphemeral = true
+}
+
+// Progress updates the pending message, e.g. to say how many accounts have
+// been searched. It does nothing if the pending message hasn't been shown,
+// or the handler has already responded.
+//
+// Progress never waits for slack. Updates are sent in the background, and if
+// slack is slow then only the latest update is sent
+func (m MessageResponder) Progress(text string) {
+	m.state.progressSender.Do(func() {
+		go m.sendProgressUpdates()
+	})
+
+	// Replace any update that's still waiting to be sent
+	select {
+	case <-m.state.progress:
+	default:
+	}
+
+	select {
+	case m.state.progress <- text:
+	default:
+	}
+}
+
+// sendProgressUpdates sends progress updates until the handler responds
+func (m MessageResponder) sendProgressUpdates() {
+	for {
+		select {
+		case text := <-m.state.progress:
+			m.sendProgress(text)
+		case <-m.state.done:
+			return
+		}
+	}
+}
+
+// sendProgress makes a single attempt to update the pending message
+func (m MessageResponder) sendProgress(text string) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	if m.state.answered || getNowTime().Sub(m.state.lastProgress) < minProgressInterval {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), progressTimeout)
+	defer cancel()
+
+	var err error
+
+	switch {
+	case m.state.pendingMessage != nil:
+		err = m.client.UpdateMessage(ctx, MessageUpdate{
+			Channel: m.state.pendingMessage.Channel,
+			TS:      m.state.pendingMessage.TS,
+			Text:    text,
+		})
+
+	case m.state.pendingEphemeral && m.state.progressUpdates < maxResponseURLProgressUpdates:
+		var b []byte
+		b, err = json.Marshal(Response{ResponseType: ResponseEphemeral, Text: text, ReplaceOriginal: true})
+		if err == nil {
+			_, err = m.deliver(ctx, b)
+		}
+		m.state.progressUpdates++
+
+	default:
+		return
+	}
+
+	m.state.lastProgress = getNowTime()
+
+	// Progress is nice to have, so isn't worth reporting
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// EphemeralResponse shows a response to the user who ran the command
+func (m MessageResponder) EphemeralResponse(resp Response) error {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	m.state.answer()
+	resp.ResponseType = ResponseEphemeral
+
+	switch {
+	case m.state.pendingMessage != nil:
+		// A public message can't be turned into an ephemeral one
+		if err := m.client.DeleteMessage(context.Background(), m.state.pendingMessage.Channel, m.state.pendingMessage.TS); err != nil {
+			log.Print(err)
+		}
+
+	case m.state.pendingEphemeral:
+		resp.ReplaceOriginal = true
+	}
+
+	err := m.post(resp)
+	if isResponseURLUnusable(err) && m.client != nil && m.user != "" {
+		err = m.client.PostEphemeral(context.Background(), EphemeralMessage{
+			Channel: m.channel,
+			User:    m.user,
+			Text:    resp.Text,
+			Blocks:  resp.Blocks,
+		})
+	}
+
+	if err != nil {
+		m.report(err)
+	}
+
+	return err
+}
+
+// PublicResponse shows a response to everyone in the channel
+func (m MessageResponder) PublicResponse(resp Response) error {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	m.state.answer()
+	resp.ResponseType = ResponseInChannel
+
+	switch {
+	case m.state.pendingMessage != nil && !resp.ReplaceOriginal:
+		err := m.client.UpdateMessage(context.Background(), MessageUpdate{
+			Channel: m.state.pendingMessage.Channel,
+			TS:      m.state.pendingMessage.TS,
+			Text:    resp.Text,
+			Blocks:  resp.Blocks,
+		})
+		if err == nil {
+			return nil
+		}
+
+		// e.g. the blocks were too big for chat.update. The response is
+		// sent separately, so the pending message mustn't be left behind
+		log.Print(err)
+		if err := m.client.DeleteMessage(context.Background(), m.state.pendingMessage.Channel, m.state.pendingMessage.TS); err != nil {
+			log.Print(err)
+		}
+
+	case m.state.pendingEphemeral:
+		// An ephemeral message can't be made public, so we remove it and
+		// send the response separately. If that fails the user is left
+		// with the pending message, but still gets the response
+		if err := m.post(Response{DeleteOriginal: true}); err != nil {
+			log.Print(err)
+		}
+	}
+
+	err := m.post(resp)
+	if isResponseURLUnusable(err) && m.client != nil && m.channel != "" && !resp.ReplaceOriginal {
+		_, err = m.client.PostMessage(context.Background(), Message{
+			Channel: m.channel,
+			Text:    resp.Text,
+			Blocks:  resp.Blocks,
+		})
+	}
+
+	if err != nil {
+		m.report(err)
+	}
+
+	return err
+}
+
+// report sends failures to bugsnag. Response URLs expiring or being used up
+// are expected now and then (e.g. for slow searches), so they're only logged
+func (m MessageResponder) report(err error) {
+	log.Print(err)
+
+	if !isResponseURLUnusable(err) {
+		bugsnag.Notify(err)
+	}
+}
+
+func isResponseURLUnusable(err error) bool {
+	return errors.Is(err, ErrResponseURLExpired) || errors.Is(err, ErrResponseURLUsed)
+}
+
+// post sends a message to the response URL, retrying with a backoff if slack
+// returns a server error or can't be reached
+func (m MessageResponder) post(resp Response) error {
+	b, err := json.Marshal(&resp)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := m.deliver(context.Background(), b)
+		if err == nil || !retry || attempt >= maxDeliveryAttempts {
+			return err
+		}
+
+		log.Printf("%s, retrying", err)
+		time.Sleep(deliveryBackoff << uint(attempt-1))
+	}
+}
+
+// deliver makes a single attempt to send a message to the response URL, and
+// reports whether it's worth trying again if it failed
+func (m MessageResponder) deliver(ctx context.Context, body []byte) (bool, error) {
+	r, err := http.NewRequest("POST", m.responseURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := slackClient.Do(r)
+	if err != nil {
+		return true, fmt.Errorf("response_url: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// Slack responds with "ok", or a short error code, so there's no need
+	// to read much of the body
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("response_url: unexpected status %s", resp.Status)
+	}
+
+	switch responseURLErrorCode(respBody) {
+	case "":
+	case "expired_url":
+		return false, ErrResponseURLExpired
+	case "used_url":
+		return false, ErrResponseURLUsed
+	default:
+		return false, fmt.Errorf("response_url: %s", responseURLErrorCode(respBody))
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Errorf("response_url: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	return false, nil
+}
+
+// responseURLErrorCode reads the error code from the response to a message
+// sent to a response URL. Slack either responds with JSON like the Web API,
+// or with the bare error code
+func responseURLErrorCode(body []byte) string {
+	var envelope struct {
+		OK    *bool  `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil {
+		if envelope.OK != nil && !*envelope.OK {
+			return envelope.Error
+		}
+
+		return ""
+	}
+
+	switch code := strings.TrimSpace(string(body)); code {
+	case "expired_url", "used_url", "invalid_blocks", "no_text":
+		return code
+	}
+
+	return ""
+}
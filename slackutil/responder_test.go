@@ -0,0 +1,337 @@
+package slackutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSlack records every message sent to a response URL, or to the Web API
+type fakeSlack struct {
+	mu       sync.Mutex
+	requests []fakeSlackRequest
+
+	// Responses to send to the response URL before accepting messages
+	failures []fakeSlackFailure
+
+	// Error codes to return from Web API methods, keyed by path
+	apiErrors map[string]string
+
+	// How long to take to respond to every request
+	delay time.Duration
+}
+
+type fakeSlackFailure struct {
+	status int
+	body   string
+}
+
+type fakeSlackRequest struct {
+	Path string
+	Body map[string]interface{}
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	f.mu.Lock()
+	delay := f.delay
+	f.mu.Unlock()
+	time.Sleep(delay)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeSlackRequest{Path: r.URL.Path, Body: body})
+
+	if code, ok := f.apiErrors[r.URL.Path]; ok {
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": code})
+		return
+	}
+
+	if r.URL.Path == "/response_url" && len(f.failures) > 0 {
+		failure := f.failures[0]
+		f.failures = f.failures[1:]
+		f.mu.Unlock()
+
+		w.WriteHeader(failure.status)
+		w.Write([]byte(failure.body))
+		return
+	}
+	f.mu.Unlock()
+
+	w.Write([]byte(`{"ok": true, "channel": "C0LAN2Q65", "ts": "1548261232.000300"}`))
+}
+
+func (f *fakeSlack) summary() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	summary := []string{}
+	for _, request := range f.requests {
+		line := request.Path
+		for _, key := range []string{"response_type", "replace_original", "delete_original", "text"} {
+			if value, ok := request.Body[key]; ok {
+				b, _ := json.Marshal(value)
+				line += " " + key + "=" + string(b)
+			}
+		}
+		summary = append(summary, line)
+	}
+
+	return summary
+}
+
+func assertRequests(t *testing.T, slack *fakeSlack, expected ...string) {
+	t.Helper()
+
+	actual := slack.summary()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected requests %#v, got %#v", expected, actual)
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("request %d: expected %q, got %q", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestMessageResponder(t *testing.T) {
+	now := time.Now()
+	getNowTime = func() time.Time { return now }
+	defer func() { getNowTime = time.Now }()
+
+	deliveryBackoff = time.Millisecond
+	defer func() { deliveryBackoff = 250 * time.Millisecond }()
+
+	setup := func() (*fakeSlack, MessageResponder, func()) {
+		slack := &fakeSlack{}
+		server := httptest.NewServer(slack)
+		return slack, newMessageResponder(server.URL + "/response_url"), server.Close
+	}
+
+	t.Run("It removes the pending message before sending a public response", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder.showPending(Response{Text: "Hang on"})
+		responder.PublicResponse(Response{Text: "Found it"})
+
+		assertRequests(t, slack,
+			`/response_url response_type="ephemeral" text="Hang on"`,
+			`/response_url delete_original=true text=""`,
+			`/response_url response_type="in_channel" text="Found it"`,
+		)
+	})
+
+	t.Run("It replaces the pending message with an ephemeral response", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder.showPending(Response{Text: "Hang on"})
+		responder.EphemeralResponse(Response{Text: "Found it"})
+
+		assertRequests(t, slack,
+			`/response_url response_type="ephemeral" text="Hang on"`,
+			`/response_url response_type="ephemeral" replace_original=true text="Found it"`,
+		)
+	})
+
+	t.Run("It doesn't show the pending message once the handler has responded", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder.PublicResponse(Response{Text: "Found it"})
+		responder.showPending(Response{Text: "Hang on"})
+		responder.Progress("searched 1/2 accounts")
+
+		assertRequests(t, slack, `/response_url response_type="in_channel" text="Found it"`)
+	})
+
+	t.Run("It limits how many progress updates use the response URL", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder.showPending(Response{Text: "Hang on"})
+		for i := 0; i < 4; i++ {
+			now = now.Add(minProgressInterval)
+			responder.sendProgress("searching")
+		}
+		responder.sendProgress("dropped, too soon after the last update")
+
+		assertRequests(t, slack,
+			`/response_url response_type="ephemeral" text="Hang on"`,
+			`/response_url response_type="ephemeral" replace_original=true text="searching"`,
+			`/response_url response_type="ephemeral" replace_original=true text="searching"`,
+		)
+	})
+
+	t.Run("It updates the pending message in place with the bot token", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder = responder.withClient(&Client{apiURL: responder.responseURL[:len(responder.responseURL)-len("response_url")]}, "C0LAN2Q65", "U2CERLKJA")
+		responder.pendingInChannel = true
+
+		responder.showPending(Response{Text: "Hang on"})
+		now = now.Add(minProgressInterval)
+		responder.sendProgress("searched 1/2 accounts")
+		responder.PublicResponse(Response{Text: "Found it"})
+
+		assertRequests(t, slack,
+			`/chat.postMessage text="Hang on"`,
+			`/chat.update text="searched 1/2 accounts"`,
+			`/chat.update text="Found it"`,
+		)
+	})
+
+	t.Run("It removes the pending message if it can't be updated", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder = responder.withClient(&Client{apiURL: responder.responseURL[:len(responder.responseURL)-len("response_url")]}, "C0LAN2Q65", "U2CERLKJA")
+		responder.pendingInChannel = true
+
+		responder.showPending(Response{Text: "Hang on"})
+		slack.apiErrors = map[string]string{"/chat.update": "invalid_blocks"}
+
+		if err := responder.PublicResponse(Response{Text: "Found it"}); err != nil {
+			t.Fatal(err)
+		}
+
+		assertRequests(t, slack,
+			`/chat.postMessage text="Hang on"`,
+			`/chat.update text="Found it"`,
+			`/chat.delete`,
+			`/response_url response_type="in_channel" text="Found it"`,
+		)
+	})
+
+	t.Run("It doesn't hold up a search while slack is slow", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder = responder.withClient(&Client{apiURL: responder.responseURL[:len(responder.responseURL)-len("response_url")]}, "C0LAN2Q65", "U2CERLKJA")
+		responder.pendingInChannel = true
+		responder.showPending(Response{Text: "Hang on"})
+
+		slack.mu.Lock()
+		slack.delay = 500 * time.Millisecond
+		slack.mu.Unlock()
+
+		// Like a search, every account has finished and is waiting to be
+		// collected, but the search gives up on accounts that haven't been
+		// collected in time
+		const accounts = 5
+		completed := make(chan string, accounts)
+		for i := 0; i < accounts; i++ {
+			completed <- "ok"
+		}
+		deadline := time.After(200 * time.Millisecond)
+
+		collected := 0
+	collect:
+		for collected < accounts {
+			select {
+			case <-completed:
+				collected++
+				now = now.Add(minProgressInterval)
+				responder.Progress(fmt.Sprintf("searched %d/%d accounts", collected, accounts))
+			case <-deadline:
+				break collect
+			}
+		}
+
+		if collected != accounts {
+			t.Errorf("expected every account to be reported OK, but only %d/%d were collected in time", collected, accounts)
+		}
+	})
+
+	t.Run("It retries when slack has problems", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		slack.failures = []fakeSlackFailure{{http.StatusInternalServerError, ""}, {http.StatusBadGateway, ""}}
+
+		if err := responder.PublicResponse(Response{Text: "Found it"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(slack.summary()) != 3 {
+			t.Errorf("expected 3 attempts, got %#v", slack.summary())
+		}
+	})
+
+	t.Run("It gives up after a few attempts", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		for i := 0; i < maxDeliveryAttempts; i++ {
+			slack.failures = append(slack.failures, fakeSlackFailure{http.StatusServiceUnavailable, ""})
+		}
+
+		if err := responder.PublicResponse(Response{Text: "Found it"}); err == nil {
+			t.Error("expected an error")
+		}
+
+		if len(slack.summary()) != maxDeliveryAttempts {
+			t.Errorf("expected %d attempts, got %#v", maxDeliveryAttempts, slack.summary())
+		}
+	})
+
+	t.Run("It doesn't retry client errors", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		slack.failures = []fakeSlackFailure{{http.StatusBadRequest, "invalid_blocks"}}
+
+		if err := responder.PublicResponse(Response{Text: "Found it"}); err == nil || err.Error() != "response_url: invalid_blocks" {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		if len(slack.summary()) != 1 {
+			t.Errorf("expected 1 attempt, got %#v", slack.summary())
+		}
+	})
+
+	t.Run("It returns an error if the response URL has expired", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		slack.failures = []fakeSlackFailure{{http.StatusNotFound, "expired_url"}}
+
+		if err := responder.EphemeralResponse(Response{Text: "Found it"}); err != ErrResponseURLExpired {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+
+	t.Run("It posts with the bot token if the response URL has been used up", func(t *testing.T) {
+		slack, responder, done := setup()
+		defer done()
+
+		responder = responder.withClient(&Client{apiURL: responder.responseURL[:len(responder.responseURL)-len("response_url")]}, "C0LAN2Q65", "U2CERLKJA")
+		slack.failures = []fakeSlackFailure{{http.StatusOK, `{"ok": false, "error": "used_url"}`}}
+
+		if err := responder.PublicResponse(Response{Text: "Found it"}); err != nil {
+			t.Fatal(err)
+		}
+
+		assertRequests(t, slack,
+			`/response_url response_type="in_channel" text="Found it"`,
+			`/chat.postMessage text="Found it"`,
+		)
+	})
+
+	t.Run("It returns an error if slack can't be reached", func(t *testing.T) {
+		responder := newMessageResponder("http://127.0.0.1:0/response_url")
+
+		if err := responder.PublicResponse(Response{Text: "Found it"}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
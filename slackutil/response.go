@@ -16,9 +16,22 @@ const (
 
 // Response is the response to a slash command
 type Response struct {
-	ResponseType string       `json:"response_type,omitempty"`
-	Text         string       `json:"text"`
-	Attachments  []Attachment `json:"attachments,omitempty"`
+	ResponseType string `json:"response_type,omitempty"`
+
+	// When the response has blocks, Text is only used in notifications
+	Text        string       `json:"text"`
+	Blocks      []Block      `json:"blocks,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// When responding to an interaction, these change the message the user
+	// interacted with rather than posting a new one
+	ReplaceOriginal bool `json:"replace_original,omitempty"`
+	DeleteOriginal  bool `json:"delete_original,omitempty"`
+}
+
+// Validate checks that slack will accept the response's blocks
+func (r Response) Validate() error {
+	return ValidateBlocks(r.Blocks)
 }
 
 // Attachment is Slack attachment for slash Response
@@ -0,0 +1,381 @@
+package slackutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	bugsnag "github.com/bugsnag/bugsnag-go"
+	"github.com/gorilla/websocket"
+)
+
+// Socket Mode envelope types
+// https://api.slack.com/apis/connections/socket-implement
+const (
+	SocketHello         = "hello"
+	SocketDisconnect    = "disconnect"
+	SocketEventsAPI     = "events_api"
+	SocketSlashCommands = "slash_commands"
+	SocketInteractive   = "interactive"
+)
+
+// Why slack asked us to disconnect
+const (
+	// The connection is about to be refreshed
+	DisconnectWarning = "warning"
+
+	// Slack wants us to reconnect, which happens every few hours
+	DisconnectRefreshRequested = "refresh_requested"
+
+	// Socket Mode was turned off for the app
+	DisconnectLinkDisabled = "link_disabled"
+)
+
+const (
+	// How often we ping slack to check the connection is still alive. If
+	// nothing is received for two intervals, we reconnect
+	socketPingInterval = 30 * time.Second
+
+	// The longest we wait between attempts to reconnect
+	maxSocketReconnectDelay = 30 * time.Second
+)
+
+// How long we wait before reconnecting after a connection fails. This
+// doubles with each failure, up to maxSocketReconnectDelay. It's a variable
+// so that tests don't have to wait
+var initialSocketReconnectDelay = time.Second
+
+// ErrSocketModeDisabled is returned by SocketMode.Run if Socket Mode is
+// turned off for the app while we're connected
+var ErrSocketModeDisabled = errors.New("socket mode was disabled for the app")
+
+// SocketEnvelope is a message slack sends over a Socket Mode connection
+type SocketEnvelope struct {
+	Type       string `json:"type"`
+	EnvelopeID string `json:"envelope_id"`
+
+	// The body slack would otherwise have POSTed to a request URL
+	Payload json.RawMessage `json:"payload"`
+
+	// Set if slack will use a payload in the acknowledgement, e.g. as the
+	// immediate response to a slash command
+	AcceptsResponsePayload bool `json:"accepts_response_payload"`
+
+	// Set when an event is being delivered again
+	RetryAttempt int    `json:"retry_attempt"`
+	RetryReason  string `json:"retry_reason"`
+
+	// Only set for disconnect messages
+	Reason string `json:"reason"`
+}
+
+// socketAck acknowledges an envelope. Slack redelivers envelopes that
+// aren't acknowledged within 3 seconds
+type socketAck struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+// SocketMode receives slash commands, interactions and events over a
+// websocket, rather than having slack POST them to a public URL. Each one is
+// turned back in to the HTTP request slack would have sent, and passed to
+// Handler, so the same handlers serve both
+// https://api.slack.com/apis/connections/socket
+type SocketMode struct {
+	// A client using an app-level token with the connections:write scope
+	Client *Client
+
+	// Serves the requests at the paths below. Requests arriving over the
+	// websocket aren't signed, as the connection is authenticated by the
+	// app-level token
+	Handler http.Handler
+
+	SlashCommandPath string
+	InteractionsPath string
+	EventsPath       string
+
+	// Used to connect, defaults to websocket.DefaultDialer
+	Dialer *websocket.Dialer
+}
+
+// OpenConnection asks slack for a URL to open a Socket Mode websocket to.
+// The client must use an app-level token
+// https://api.slack.com/methods/apps.connections.open
+func (c *Client) OpenConnection(ctx context.Context) (string, error) {
+	var connection struct {
+		URL string `json:"url"`
+	}
+
+	if err := c.do(ctx, "apps.connections.open", "application/x-www-form-urlencoded", nil, &connection); err != nil {
+		return "", err
+	}
+
+	return connection.URL, nil
+}
+
+// Run connects to slack and handles envelopes until ctx is cancelled. Lost
+// connections are reopened, backing off if they keep failing, and slack's
+// requests to refresh the connection are followed straight away
+func (s *SocketMode) Run(ctx context.Context) error {
+	delay := initialSocketReconnectDelay
+
+	for {
+		connected, err := s.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == ErrSocketModeDisabled {
+			return err
+		}
+
+		if connected {
+			delay = initialSocketReconnectDelay
+		}
+
+		if err != nil {
+			log.Printf("socket mode connection lost, reconnecting in %s: %s", delay, err)
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil
+			}
+
+			if delay *= 2; delay > maxSocketReconnectDelay {
+				delay = maxSocketReconnectDelay
+			}
+		}
+	}
+}
+
+// connect opens a single connection and handles envelopes until it's closed.
+// connected reports whether slack said hello, so that Run knows the
+// connection worked. A nil error means slack asked us to reconnect
+func (s *SocketMode) connect(ctx context.Context) (connected bool, err error) {
+	wsURL, err := s.Client.OpenConnection(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	dialer := s.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+
+	conn, _, err := dialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	socket := newSocketConn(conn)
+	defer socket.close()
+
+	// Closing the connection is the only way to interrupt a read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			socket.close()
+		case <-done:
+		}
+	}()
+
+	go socket.keepAlive(done)
+
+	for {
+		var envelope SocketEnvelope
+		if err := socket.read(&envelope); err != nil {
+			return connected, err
+		}
+
+		switch envelope.Type {
+		case SocketHello:
+			connected = true
+
+		case SocketDisconnect:
+			switch envelope.Reason {
+			case DisconnectWarning:
+				// Slack sends a refresh_requested shortly afterwards
+				continue
+			case DisconnectLinkDisabled:
+				return connected, ErrSocketModeDisabled
+			default:
+				return connected, nil
+			}
+
+		case SocketEventsAPI, SocketSlashCommands, SocketInteractive:
+			go s.handle(socket, envelope)
+
+		default:
+			log.Printf("ignoring socket mode envelope of type %q", envelope.Type)
+		}
+	}
+}
+
+// handle passes an envelope to the HTTP handler, and acknowledges it with
+// whatever the handler responded with
+func (s *SocketMode) handle(socket *socketConn, envelope SocketEnvelope) {
+	defer bugsnag.AutoNotify(context.Background())
+
+	r, err := s.requestFor(envelope)
+	if err != nil {
+		log.Printf("could not handle socket mode envelope %s: %s", envelope.EnvelopeID, err)
+		bugsnag.Notify(err)
+		return
+	}
+
+	w := &socketResponse{header: http.Header{}, status: http.StatusOK}
+	s.Handler.ServeHTTP(w, r)
+
+	ack := socketAck{EnvelopeID: envelope.EnvelopeID}
+	if envelope.AcceptsResponsePayload && w.status == http.StatusOK && json.Valid(w.body.Bytes()) {
+		ack.Payload = w.body.Bytes()
+	} else if w.status != http.StatusOK {
+		log.Printf("socket mode envelope %s got status %d: %s", envelope.EnvelopeID, w.status, w.body.String())
+	}
+
+	if err := socket.write(ack); err != nil {
+		log.Printf("could not acknowledge socket mode envelope %s: %s", envelope.EnvelopeID, err)
+	}
+}
+
+// requestFor builds the request slack would have sent to the app's request
+// URL for an envelope
+func (s *SocketMode) requestFor(envelope SocketEnvelope) (*http.Request, error) {
+	var (
+		path        string
+		body        []byte
+		contentType string
+	)
+
+	switch envelope.Type {
+	case SocketSlashCommands:
+		// Slash commands are normally sent as a form, but arrive here as
+		// a JSON object of the same fields
+		var fields map[string]interface{}
+		if err := json.Unmarshal(envelope.Payload, &fields); err != nil {
+			return nil, err
+		}
+
+		form := url.Values{}
+		for key, value := range fields {
+			form.Set(key, fmt.Sprint(value))
+		}
+
+		path, body, contentType = s.SlashCommandPath, []byte(form.Encode()), "application/x-www-form-urlencoded"
+
+	case SocketInteractive:
+		form := url.Values{"payload": []string{string(envelope.Payload)}}
+		path, body, contentType = s.InteractionsPath, []byte(form.Encode()), "application/x-www-form-urlencoded"
+
+	case SocketEventsAPI:
+		path, body, contentType = s.EventsPath, envelope.Payload, "application/json"
+	}
+
+	r, err := http.NewRequest("POST", path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", contentType)
+
+	if envelope.RetryAttempt > 0 {
+		r.Header.Set(SlackRetryNumHeader, strconv.Itoa(envelope.RetryAttempt))
+		r.Header.Set(SlackRetryReasonHeader, envelope.RetryReason)
+	}
+
+	return r, nil
+}
+
+// socketConn is a websocket that can be written to from several goroutines
+type socketConn struct {
+	conn *websocket.Conn
+
+	mu        sync.Mutex
+	closeOnce sync.Once
+}
+
+func (s *socketConn) read(v interface{}) error {
+	s.conn.SetReadDeadline(time.Now().Add(2 * socketPingInterval))
+	return s.conn.ReadJSON(v)
+}
+
+func (s *socketConn) write(v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteJSON(v)
+}
+
+func newSocketConn(conn *websocket.Conn) *socketConn {
+	s := &socketConn{conn: conn}
+
+	// Any pong, ping or message from slack pushes back the read deadline
+	extend := func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(2 * socketPingInterval))
+	}
+
+	conn.SetPongHandler(extend)
+	conn.SetPingHandler(func(data string) error {
+		extend(data)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	return s
+}
+
+// keepAlive pings slack until done is closed
+func (s *socketConn) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(socketPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *socketConn) close() {
+	s.closeOnce.Do(func() {
+		s.conn.Close()
+	})
+}
+
+// socketResponse collects what a handler responds with, so it can be sent
+// back in the acknowledgement
+type socketResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *socketResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *socketResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *socketResponse) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
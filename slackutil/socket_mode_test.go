@@ -0,0 +1,256 @@
+package slackutil
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeSocketSlack stands in for slack's apps.connections.open method and
+// the websocket it returns the URL of
+type fakeSocketSlack struct {
+	server      *httptest.Server
+	opened      int32
+	connections chan *websocket.Conn
+}
+
+func newFakeSocketSlack(t *testing.T) *fakeSocketSlack {
+	f := &fakeSocketSlack{connections: make(chan *websocket.Conn, 10)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xapp-token" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+
+		atomic.AddInt32(&f.opened, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":  true,
+			"url": "ws" + strings.TrimPrefix(f.server.URL, "http") + "/link",
+		})
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		conn.WriteJSON(map[string]interface{}{"type": SocketHello, "num_connections": 1})
+		f.connections <- conn
+	})
+
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeSocketSlack) nextConnection(t *testing.T) *websocket.Conn {
+	select {
+	case conn := <-f.connections:
+		return conn
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a connection")
+		return nil
+	}
+}
+
+// socketRequest is what the HTTP handler was asked to serve
+type socketRequest struct {
+	path    string
+	headers http.Header
+	form    map[string][]string
+	body    string
+}
+
+func TestSocketMode(t *testing.T) {
+	initialSocketReconnectDelay = time.Millisecond
+	defer func() { initialSocketReconnectDelay = time.Second }()
+
+	slack := newFakeSocketSlack(t)
+	defer slack.server.Close()
+
+	requests := make(chan socketRequest, 10)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := socketRequest{path: r.URL.Path, headers: r.Header}
+		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			r.ParseForm()
+			req.form = r.PostForm
+		} else {
+			b, _ := ioutil.ReadAll(r.Body)
+			req.body = string(b)
+		}
+		requests <- req
+
+		if r.URL.Path == "/slack/infra-search" {
+			RespondWith(w, Response{ResponseType: ResponseInChannel})
+		}
+	})
+
+	socketMode := &SocketMode{
+		Client:           &Client{token: "xapp-token", apiURL: slack.server.URL + "/api/"},
+		Handler:          handler,
+		SlashCommandPath: "/slack/infra-search",
+		InteractionsPath: "/slack/interactions",
+		EventsPath:       "/slack/events",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- socketMode.Run(ctx) }()
+
+	conn := slack.nextConnection(t)
+
+	send := func(envelope map[string]interface{}) (socketRequest, socketAck) {
+		if err := conn.WriteJSON(envelope); err != nil {
+			t.Fatal(err)
+		}
+
+		var req socketRequest
+		select {
+		case req = <-requests:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the envelope to be handled")
+		}
+
+		var ack socketAck
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatal(err)
+		}
+
+		return req, ack
+	}
+
+	t.Run("It dispatches slash commands as a form and acks with the response", func(t *testing.T) {
+		req, ack := send(map[string]interface{}{
+			"type":        SocketSlashCommands,
+			"envelope_id": "1d3c4e5f",
+			"payload": map[string]interface{}{
+				"command":      "/infra-search",
+				"text":         "i-0123456789abcdef0",
+				"channel_id":   "C0LAN2Q65",
+				"response_url": "https://hooks.slack.com/commands/1234/5678",
+			},
+			"accepts_response_payload": true,
+		})
+
+		if req.path != "/slack/infra-search" || req.form["text"][0] != "i-0123456789abcdef0" || req.form["channel_id"][0] != "C0LAN2Q65" {
+			t.Errorf("unexpected request %#v", req)
+		}
+
+		var response Response
+		json.Unmarshal(ack.Payload, &response)
+		if ack.EnvelopeID != "1d3c4e5f" || response.ResponseType != ResponseInChannel {
+			t.Errorf("unexpected ack %s %s", ack.EnvelopeID, ack.Payload)
+		}
+	})
+
+	t.Run("It dispatches interactions with the payload in a form", func(t *testing.T) {
+		req, ack := send(map[string]interface{}{
+			"type":        SocketInteractive,
+			"envelope_id": "2e4d5f6a",
+			"payload":     map[string]interface{}{"type": "block_actions", "trigger_id": "123.456"},
+		})
+
+		if req.path != "/slack/interactions" || req.form["payload"][0] != `{"trigger_id":"123.456","type":"block_actions"}` {
+			t.Errorf("unexpected request %#v", req)
+		}
+
+		if ack.EnvelopeID != "2e4d5f6a" || len(ack.Payload) != 0 {
+			t.Errorf("unexpected ack %s %s", ack.EnvelopeID, ack.Payload)
+		}
+	})
+
+	t.Run("It dispatches events as JSON, marking retries", func(t *testing.T) {
+		req, ack := send(map[string]interface{}{
+			"type":          SocketEventsAPI,
+			"envelope_id":   "3f5e6a7b",
+			"payload":       map[string]interface{}{"type": EventsCallback, "event_id": "Ev0123"},
+			"retry_attempt": 1,
+			"retry_reason":  "timeout",
+		})
+
+		if req.path != "/slack/events" || req.body != `{"event_id":"Ev0123","type":"event_callback"}` {
+			t.Errorf("unexpected request %#v", req)
+		}
+
+		if req.headers.Get(SlackRetryNumHeader) != "1" || req.headers.Get(SlackRetryReasonHeader) != "timeout" {
+			t.Errorf("expected the request to be marked as a retry, got %v", req.headers)
+		}
+
+		if ack.EnvelopeID != "3f5e6a7b" {
+			t.Errorf("unexpected ack %s", ack.EnvelopeID)
+		}
+	})
+
+	t.Run("It reconnects when slack asks it to refresh", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": SocketDisconnect, "reason": DisconnectWarning})
+		conn.WriteJSON(map[string]interface{}{"type": SocketDisconnect, "reason": DisconnectRefreshRequested})
+
+		conn = slack.nextConnection(t)
+
+		if opened := atomic.LoadInt32(&slack.opened); opened != 2 {
+			t.Errorf("expected a new connection to be opened, got %d", opened)
+		}
+	})
+
+	t.Run("It reconnects when the connection is lost", func(t *testing.T) {
+		conn.Close()
+
+		conn = slack.nextConnection(t)
+
+		if opened := atomic.LoadInt32(&slack.opened); opened != 3 {
+			t.Errorf("expected a new connection to be opened, got %d", opened)
+		}
+	})
+
+	t.Run("It stops when socket mode is disabled", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": SocketDisconnect, "reason": DisconnectLinkDisabled})
+
+		select {
+		case err := <-stopped:
+			if err != ErrSocketModeDisabled {
+				t.Errorf("unexpected error %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected Run to stop")
+		}
+	})
+}
+
+func TestSocketModeStopsWhenCancelled(t *testing.T) {
+	slack := newFakeSocketSlack(t)
+	defer slack.server.Close()
+
+	socketMode := &SocketMode{
+		Client:  &Client{token: "xapp-token", apiURL: slack.server.URL + "/api/"},
+		Handler: http.NotFoundHandler(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- socketMode.Run(ctx) }()
+
+	slack.nextConnection(t)
+	cancel()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Run to stop")
+	}
+}
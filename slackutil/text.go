@@ -0,0 +1,53 @@
+package slackutil
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// e.g. <@U0123ABCD> or <@U0123ABCD|someone>
+	userMentionPattern = regexp.MustCompile(`<@[A-Z0-9]+(?:\|[^>]*)?>`)
+
+	// e.g. <http://example.com|example.com>, <mailto:a@b.com> or <#C0123|general>
+	formattedLinkPattern = regexp.MustCompile(`<([^@>|][^>|]*)(?:\|([^>]*))?>`)
+
+	// Any link or mention slack formatted
+	anyFormattedLinkPattern = regexp.MustCompile(`<[^>]*>`)
+
+	escapedText = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+	textToEscape = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;")
+)
+
+// EscapeText escapes the characters slack uses for formatting, so that text
+// like `<query>` isn't mistaken for a link
+// https://api.slack.com/reference/surfaces/formatting#escaping
+func EscapeText(text string) string {
+	return textToEscape.Replace(text)
+}
+
+// PlainMessageText turns the text of a message into what the user typed.
+// User mentions are removed, and links slack added (e.g. to DNS names) are
+// replaced with their labels
+// https://api.slack.com/reference/surfaces/formatting#retrieving-messages
+func PlainMessageText(text string) string {
+	text = userMentionPattern.ReplaceAllString(text, "")
+
+	text = formattedLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		match := formattedLinkPattern.FindStringSubmatch(link)
+		if match[2] != "" {
+			return match[2]
+		}
+
+		return strings.TrimPrefix(match[1], "mailto:")
+	})
+
+	return strings.TrimSpace(escapedText.Replace(text))
+}
+
+// WithoutLinks removes every link and mention from the text of a message,
+// e.g. so that we can look for identifiers in just what the user typed
+func WithoutLinks(text string) string {
+	return strings.TrimSpace(escapedText.Replace(anyFormattedLinkPattern.ReplaceAllString(text, "")))
+}
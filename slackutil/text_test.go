@@ -0,0 +1,34 @@
+package slackutil
+
+import "testing"
+
+func TestPlainMessageText(t *testing.T) {
+	examples := map[string]string{
+		"<@U0LAN0Z89> i-0123456789abcdef0":                                        "i-0123456789abcdef0",
+		"<@U0LAN0Z89|infra>   10.1.2.3 ":                                          "10.1.2.3",
+		"<@U0LAN0Z89> <http://ip-10-1-2-3.ec2.internal|ip-10-1-2-3.ec2.internal>": "ip-10-1-2-3.ec2.internal",
+		"<@U0LAN0Z89> <http://ec2-3-8-1-2.eu-west-2.compute.amazonaws.com>":       "http://ec2-3-8-1-2.eu-west-2.compute.amazonaws.com",
+		"<@U0LAN0Z89> name:api &amp; worker":                                      "name:api & worker",
+		"<@U0LAN0Z89>":                                                            "",
+	}
+
+	for text, expected := range examples {
+		if actual := PlainMessageText(text); actual != expected {
+			t.Errorf("expected %q to become %q, got %q", text, expected, actual)
+		}
+	}
+}
+
+func TestWithoutLinks(t *testing.T) {
+	text := "<https://console.aws.amazon.com/ec2/v2/home#Instances:search=i-0123456789abcdef0> is down, cc <@U0LAN0Z89> &amp; i-0fedcba9876543210"
+
+	if actual := WithoutLinks(text); actual != "is down, cc  & i-0fedcba9876543210" {
+		t.Errorf("unexpected text %q", actual)
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	if escaped := EscapeText("search <query> & more"); escaped != "search &lt;query&gt; &amp; more" {
+		t.Errorf("unexpected escaped text %q", escaped)
+	}
+}
@@ -22,7 +22,13 @@ resource "aws_iam_role_policy" "allow-read-only-access" {
 
 data "aws_iam_policy_document" "allow-read-only-access" {
   statement {
-    actions   = ["ec2:DescribeInstances"]
+    actions = [
+      "ec2:DescribeInstances",
+      "ec2:DescribeAddresses",
+      "ec2:DescribeSecurityGroups",
+      "ec2:GetConsoleOutput",
+    ]
+
     resources = ["*"]
   }
 }